@@ -11,6 +11,7 @@ import (
 	"time"
 
 	"do-ddns/server/api"
+	"do-ddns/server/envsecret"
 
 	"github.com/crewjam/errset"
 	_ "github.com/joho/godotenv/autoload"
@@ -27,11 +28,24 @@ func mustGetenv(key string) string {
 	return retv
 }
 
-func update(endpoint string) error {
-	domain := mustGetenv("DDNS_DOMAIN")
+// mustGetSecret returns the secret named by the given environment variable, which may be given directly, in
+// a file named by the corresponding _FILE variable, or as a systemd credential. It exits with an error if the
+// secret is missing or can't be read.
+func mustGetSecret(key string) string {
+	retv, ok, err := envsecret.Lookup(key)
+	if err != nil {
+		log.Fatalln(err.Error())
+	}
+	if !ok {
+		log.Fatalf("environment variable '%s' (or '%s%s') is missing\n", key, key, envsecret.FileSuffix)
+	}
+	return retv
+}
+
+func update(endpoint string, domain string, secret string) error {
 	updateBody := api.DomainUpdateRequest{
 		Domain: domain,
-		Secret: mustGetenv("DDNS_SECRET"),
+		Secret: secret,
 	}
 	updateJson, err := json.Marshal(updateBody)
 	if err != nil {
@@ -57,15 +71,15 @@ func update(endpoint string) error {
 	return nil
 }
 
-func runUpdates(ipv4UpdateEndpoint string, ipv6UpdateEndpoint string) {
+func runUpdates(ipv4UpdateEndpoint string, ipv6UpdateEndpoint string, domain string, secret string) {
 	errs := errset.ErrSet{}
 	if ipv4UpdateEndpoint != "" {
-		if err := update(ipv4UpdateEndpoint); err != nil {
+		if err := update(ipv4UpdateEndpoint, domain, secret); err != nil {
 			errs = append(errs, err)
 		}
 	}
 	if ipv6UpdateEndpoint != "" {
-		if err := update(ipv6UpdateEndpoint); err != nil {
+		if err := update(ipv6UpdateEndpoint, domain, secret); err != nil {
 			errs = append(errs, err)
 		}
 	}
@@ -89,13 +103,15 @@ func main() {
 	if ipv4UpdateEndpoint == "" && ipv6UpdateEndpoint == "" {
 		log.Fatalln("at least one of the environment variables DDNS_UPDATE_ENDPOINT_A and DDNS_UPDATE_ENDPOINT_AAAA must be set")
 	}
+	domain := mustGetenv("DDNS_DOMAIN")
+	secret := mustGetSecret("DDNS_SECRET")
 
 	if *runOneShot {
-		runUpdates(ipv4UpdateEndpoint, ipv6UpdateEndpoint)
+		runUpdates(ipv4UpdateEndpoint, ipv6UpdateEndpoint, domain, secret)
 	} else {
-		runUpdates(ipv4UpdateEndpoint, ipv6UpdateEndpoint)
+		runUpdates(ipv4UpdateEndpoint, ipv6UpdateEndpoint, domain, secret)
 		for _ = range time.Tick(updateInterval) {
-			runUpdates(ipv4UpdateEndpoint, ipv6UpdateEndpoint)
+			runUpdates(ipv4UpdateEndpoint, ipv6UpdateEndpoint, domain, secret)
 		}
 	}
 }
@@ -4,9 +4,13 @@ go 1.13
 
 require (
 	github.com/crewjam/errset v0.0.0-20160219153700-f78d65de925c
+	github.com/fsnotify/fsnotify v1.5.1
 	github.com/gorilla/mux v1.7.3
 	github.com/gorilla/schema v1.4.1
 	github.com/joho/godotenv v1.3.0
 	github.com/kr/pretty v0.1.0 // indirect
+	github.com/pelletier/go-toml v1.9.5
+	golang.org/x/crypto v0.0.0-20210322153248-0c34fe9e7dc2
 	gopkg.in/check.v1 v1.0.0-20190902080502-41f04d3bba15 // indirect
+	gopkg.in/yaml.v3 v3.0.1
 )
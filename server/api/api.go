@@ -2,8 +2,8 @@ package api
 
 // DomainUpdateRequest represents a request POSTed by a client to update a domain.
 type DomainUpdateRequest struct {
-	Domain               string `json:"domain"`
-	Secret               string `json:"secret"`
+	Domain string `json:"domain"`
+	Secret string `json:"secret"`
 }
 
 // DynDnsUpdateRequest represents a GET request by the client to the DynDns-style API endpoint.
@@ -11,11 +11,23 @@ type DynDnsUpdateRequest struct {
 	Hostnames string `schema:"hostname"`
 	MyIP      string `schema:"myip"`
 	// the following are accepted without error, and ignored:
-	System    string `schema:"system"`
-	URL       string `schema:"url"`
+	System string `schema:"system"`
+	URL    string `schema:"url"`
 	// the following are not implemented:
-	Wildcard  string `schema:"wildcard"`
-	MX        string `schema:"mx"`
-	BackMX    string `schema:"backmx"`
-	Offline   string `schema:"offline"`
+	Wildcard string `schema:"wildcard"`
+	MX       string `schema:"mx"`
+	BackMX   string `schema:"backmx"`
+	Offline  string `schema:"offline"`
+}
+
+// DomainStatusRequest represents a GET request by the client for a domain's current status.
+type DomainStatusRequest struct {
+	Hostname string `schema:"hostname"`
+}
+
+// DomainStatusResponse describes a domain's current A and AAAA records.
+type DomainStatusResponse struct {
+	Domain string   `json:"domain"`
+	A      []string `json:"a"`
+	AAAA   []string `json:"aaaa"`
 }
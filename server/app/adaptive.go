@@ -0,0 +1,164 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+)
+
+// AdaptiveTTL configures a domain's records to use a short TTL right after its address changes, raising the TTL
+// step by step while the address stays stable: the TTL starts at Min, and doubles (up to Max) each time the
+// address has been stable for as long as the current TTL.
+type AdaptiveTTL struct {
+	Min int `json:"min"` // the TTL, in seconds, used immediately after the address changes
+	Max int `json:"max"` // the TTL, in seconds, used once the address has been stable for a while
+}
+
+// TTLAt returns the TTL to use once the address has been stable for the given duration.
+func (a AdaptiveTTL) TTLAt(stableFor time.Duration) int {
+	ttl := a.Min
+	// each step lasts as long as its TTL, so the TTL doubles once stableFor covers every step so far
+	stepsEnd := time.Duration(ttl) * time.Second
+	for ttl < a.Max && stableFor >= stepsEnd {
+		ttl *= 2
+		stepsEnd += time.Duration(ttl) * time.Second
+	}
+	if ttl > a.Max {
+		ttl = a.Max
+	}
+	return ttl
+}
+
+func (a AdaptiveTTL) validate() error {
+	if a.Min < MinTTL {
+		return fmt.Errorf("adaptiveTTL.min must be at least %d seconds (got %d)", MinTTL, a.Min)
+	}
+	if a.Max < a.Min {
+		return errors.New("adaptiveTTL.max must be at least adaptiveTTL.min")
+	}
+	return nil
+}
+
+// addressChange records when a domain's address last changed, as seen by the server.
+type addressChange struct {
+	value     string
+	changedAt time.Time // zero if the address hasn't changed since the server started
+}
+
+// addressHistory tracks changes to each domain's addresses, for adaptive TTLs.
+// It's kept in memory, so it starts afresh (from the addresses in DNS) when the server restarts.
+type addressHistory struct {
+	mutex   sync.Mutex
+	changes map[string]addressChange
+}
+
+func addressHistoryKey(c DomainConfig, recordType string) string {
+	return normalizeDomain(c.Domain) + ":" + recordType
+}
+
+// SeedAddressHistory starts tracking the address of the domain's record of the given type, if the domain has an
+// adaptive TTL and the server hasn't seen the record's address yet. The address is read from the domain's DNS
+// provider (using the given context), so the first update after the server starts is treated as a change only if
+// the reported address differs from the one in DNS.
+func (e *Env) SeedAddressHistory(ctx context.Context, c DomainConfig, recordType string) error {
+	if c.AdaptiveTTL == nil {
+		return nil
+	}
+	key := addressHistoryKey(c, recordType)
+	e.addressHistory.mutex.Lock()
+	_, ok := e.addressHistory.changes[key]
+	e.addressHistory.mutex.Unlock()
+	if ok {
+		return nil
+	}
+
+	zone, recordName, err := e.Zone(ctx, c)
+	if err != nil {
+		return err
+	}
+	p, err := e.Provider(c)
+	if err != nil {
+		return err
+	}
+	records, err := p.GetRecords(ctx, zone)
+	if err != nil {
+		return fmt.Errorf("failed to get records for zone '%s': %w", zone, err)
+	}
+	for _, r := range records {
+		if r.Type == recordType && strings.EqualFold(r.Name, recordName) {
+			e.addressHistory.seed(key, r.Data)
+			break
+		}
+	}
+	// if there's no such record yet, the first update is a change
+	return nil
+}
+
+// seed records the given address as having been stable since before the server started, unless the key's
+// address is already known.
+func (h *addressHistory) seed(key string, value string) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	if h.changes == nil {
+		h.changes = make(map[string]addressChange)
+	}
+	if _, ok := h.changes[key]; !ok {
+		h.changes[key] = addressChange{value: value}
+	}
+}
+
+// RecordTTL returns the TTL (in seconds) which the domain's records of the given type should have, once updated to
+// the given value: the domain's adaptive TTL if it has one, or its fixed TTL otherwise (which may be 0, to leave the
+// records' TTLs unchanged). A value which differs from the last one recorded by RecordAddress (or, since the server
+// started, by SeedAddressHistory) is a change, and gets the adaptive TTL's Min.
+func (e *Env) RecordTTL(c DomainConfig, recordType string, value string, now time.Time) int {
+	if c.AdaptiveTTL == nil {
+		return c.TTL
+	}
+
+	e.addressHistory.mutex.Lock()
+	defer e.addressHistory.mutex.Unlock()
+	last, ok := e.addressHistory.changes[addressHistoryKey(c, recordType)]
+	switch {
+	case !ok || last.value != value:
+		return c.AdaptiveTTL.Min
+	case last.changedAt.IsZero():
+		// the address may have been stable for a long time before the server started
+		return c.AdaptiveTTL.Max
+	default:
+		return c.AdaptiveTTL.TTLAt(now.Sub(last.changedAt))
+	}
+}
+
+// RecordAddress records that the domain's records of the given type were updated to the given value at the given
+// time, for adaptive TTLs. It should only be called once the update has succeeded.
+func (e *Env) RecordAddress(c DomainConfig, recordType string, value string, now time.Time) {
+	if c.AdaptiveTTL == nil {
+		return
+	}
+
+	e.addressHistory.mutex.Lock()
+	defer e.addressHistory.mutex.Unlock()
+	if e.addressHistory.changes == nil {
+		e.addressHistory.changes = make(map[string]addressChange)
+	}
+
+	key := addressHistoryKey(c, recordType)
+	last, ok := e.addressHistory.changes[key]
+	switch {
+	case ok && last.value == value:
+		return
+	case !ok:
+		log.Printf("domain '%s': %s address set; using TTL %ds", c.Domain, recordType, c.AdaptiveTTL.Min)
+	case last.changedAt.IsZero():
+		log.Printf("domain '%s': %s address changed; lowering TTL to %ds", c.Domain, recordType, c.AdaptiveTTL.Min)
+	default:
+		log.Printf("domain '%s': %s address changed after %s; lowering TTL to %ds", c.Domain, recordType,
+			now.Sub(last.changedAt).Round(time.Second), c.AdaptiveTTL.Min)
+	}
+	e.addressHistory.changes[key] = addressChange{value: value, changedAt: now}
+}
@@ -0,0 +1,137 @@
+package app
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"do-ddns/server/provider"
+)
+
+// recordLister is a provider.Provider which only lists zones and records.
+type recordLister struct {
+	provider.Provider
+	zones       []string
+	records     []provider.Record
+	recordCalls int
+}
+
+func (p *recordLister) ListZones(ctx context.Context) ([]string, error) {
+	return p.zones, nil
+}
+
+func (p *recordLister) GetRecords(ctx context.Context, zone string) ([]provider.Record, error) {
+	p.recordCalls++
+	return p.records, nil
+}
+
+func TestTTLAt(t *testing.T) {
+	tests := []struct {
+		min, max  int
+		stableFor time.Duration
+		want      int
+	}{
+		{min: 60, max: 3600, stableFor: 0, want: 60},
+		{min: 60, max: 3600, stableFor: 59 * time.Second, want: 60},
+		{min: 60, max: 3600, stableFor: 60 * time.Second, want: 120},
+		{min: 60, max: 3600, stableFor: 179 * time.Second, want: 120},
+		{min: 60, max: 3600, stableFor: 180 * time.Second, want: 240},
+		{min: 60, max: 3600, stableFor: 1860 * time.Second, want: 1920},
+		{min: 60, max: 3600, stableFor: 3779 * time.Second, want: 1920},
+		{min: 60, max: 3600, stableFor: 3780 * time.Second, want: 3600},
+		{min: 60, max: 3600, stableFor: 30 * 24 * time.Hour, want: 3600},
+		{min: 60, max: 100, stableFor: 60 * time.Second, want: 100},
+		{min: 300, max: 300, stableFor: time.Hour, want: 300},
+	}
+	for _, tt := range tests {
+		a := AdaptiveTTL{Min: tt.min, Max: tt.max}
+		if got := a.TTLAt(tt.stableFor); got != tt.want {
+			t.Errorf("%+v.TTLAt(%s) = %d, want %d", a, tt.stableFor, got, tt.want)
+		}
+	}
+}
+
+func TestRecordTTL(t *testing.T) {
+	ctx := context.Background()
+	p := &recordLister{
+		zones: []string{"example.org"},
+		records: []provider.Record{
+			{Type: "A", Name: "home", Data: "192.0.2.1"},
+			{Type: "AAAA", Name: "home", Data: "2001:db8::1"},
+		},
+	}
+	e := &Env{Providers: map[string]provider.Provider{DefaultProvider: p}}
+	c := DomainConfig{Domain: "home.example.org", AdaptiveTTL: &AdaptiveTTL{Min: 60, Max: 3600}}
+	start := time.Now()
+
+	// without an adaptive TTL, the fixed TTL is used, and the provider isn't asked for records
+	fixed := DomainConfig{Domain: "home.example.org", TTL: 300}
+	if err := e.SeedAddressHistory(ctx, fixed, "A"); err != nil {
+		t.Fatal(err)
+	}
+	if ttl := e.RecordTTL(fixed, "A", "192.0.2.9", start); ttl != 300 || p.recordCalls != 0 {
+		t.Errorf("fixed TTL: got %d after %d GetRecords calls, want 300 after none", ttl, p.recordCalls)
+	}
+
+	// a first report matching DNS has been stable since before the server started
+	for _, recordType := range []string{"A", "AAAA"} {
+		if err := e.SeedAddressHistory(ctx, c, recordType); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if ttl := e.RecordTTL(c, "AAAA", "2001:db8::1", start); ttl != 3600 {
+		t.Errorf("first report matching DNS: got TTL %d, want 3600", ttl)
+	}
+	// but a first report differing from DNS is a change
+	if ttl := e.RecordTTL(c, "A", "192.0.2.2", start); ttl != 60 {
+		t.Errorf("first report differing from DNS: got TTL %d, want 60", ttl)
+	}
+	// until the update succeeds, the history isn't changed
+	if ttl := e.RecordTTL(c, "A", "192.0.2.1", start); ttl != 3600 {
+		t.Errorf("after a failed update: got TTL %d for the address in DNS, want 3600", ttl)
+	}
+
+	// seeding happens once per record
+	if err := e.SeedAddressHistory(ctx, DomainConfig{Domain: "Home.Example.org.", AdaptiveTTL: c.AdaptiveTTL}, "A"); err != nil {
+		t.Fatal(err)
+	}
+	if p.recordCalls != 2 {
+		t.Errorf("got %d GetRecords calls, want 2", p.recordCalls)
+	}
+
+	e.RecordAddress(c, "A", "192.0.2.2", start)
+	tests := []struct {
+		value string
+		after time.Duration
+		want  int
+	}{
+		{value: "192.0.2.2", after: 0, want: 60},
+		{value: "192.0.2.2", after: 60 * time.Second, want: 120},
+		{value: "192.0.2.2", after: time.Hour, want: 1920},
+		{value: "192.0.2.1", after: time.Hour, want: 60},
+	}
+	for _, tt := range tests {
+		if ttl := e.RecordTTL(c, "A", tt.value, start.Add(tt.after)); ttl != tt.want {
+			t.Errorf("%s after %s: got TTL %d, want %d", tt.value, tt.after, ttl, tt.want)
+		}
+	}
+
+	// recording the same address again doesn't restart its history
+	e.RecordAddress(c, "A", "192.0.2.2", start.Add(time.Hour))
+	if ttl := e.RecordTTL(c, "A", "192.0.2.2", start.Add(time.Hour)); ttl != 1920 {
+		t.Errorf("after recording an unchanged address: got TTL %d, want 1920", ttl)
+	}
+}
+
+func TestRecordTTLMissingRecord(t *testing.T) {
+	p := &recordLister{zones: []string{"example.org"}}
+	e := &Env{Providers: map[string]provider.Provider{DefaultProvider: p}}
+	c := DomainConfig{Domain: "new.example.org", AdaptiveTTL: &AdaptiveTTL{Min: 60, Max: 3600}}
+
+	if err := e.SeedAddressHistory(context.Background(), c, "A"); err != nil {
+		t.Fatal(err)
+	}
+	if ttl := e.RecordTTL(c, "A", "192.0.2.1", time.Now()); ttl != 60 {
+		t.Errorf("got TTL %d for a record missing from DNS, want 60", ttl)
+	}
+}
@@ -0,0 +1,76 @@
+package app
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"do-ddns/server/configcrypt"
+)
+
+// readDomainsConfigFile reads and decodes a single domains configuration file, decrypting it if necessary.
+func (e *Env) readDomainsConfigFile(configPath string) (*DomainsConfig, error) {
+	configFile, err := ioutil.ReadFile(configPath)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't read config file '%s': %w", configPath, err)
+	}
+	if configcrypt.IsEncrypted(configFile) {
+		if e.DomainsConfigKey == nil {
+			return nil, fmt.Errorf("config file '%s' is encrypted, but no decryption key is configured", configPath)
+		}
+		if configFile, err = configcrypt.Decrypt(configFile, e.DomainsConfigKey); err != nil {
+			return nil, fmt.Errorf("couldn't decrypt config file '%s': %w", configPath, err)
+		}
+	}
+	domainsConfig, err := decodeDomainsConfig(configFile, DomainsConfigFormat(configPath))
+	if err != nil {
+		return nil, fmt.Errorf("couldn't parse config file '%s': %w", configPath, err)
+	}
+	return domainsConfig, nil
+}
+
+// readDomainsConfigDir reads every fragment in the given conf.d-style directory, in lexical order, and merges
+// their domains into one configuration. Each fragment is a complete domains configuration file in its own
+// right. Hidden files, subdirectories, and files without a supported extension are ignored.
+func (e *Env) readDomainsConfigDir(dirPath string) (*DomainsConfig, error) {
+	files, err := ioutil.ReadDir(dirPath)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't read config directory '%s': %w", dirPath, err)
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].Name() < files[j].Name() })
+
+	merged := &DomainsConfig{}
+	for _, f := range files {
+		if f.IsDir() || !isDomainsConfigFragment(f.Name()) {
+			continue
+		}
+		fragment, err := e.readDomainsConfigFile(filepath.Join(dirPath, f.Name()))
+		if err != nil {
+			return nil, err
+		}
+		for i, d := range fragment.Domains {
+			source := fragment.source(i)
+			source.File = f.Name()
+			merged.Domains = append(merged.Domains, d)
+			merged.entries = append(merged.entries, source)
+		}
+	}
+	return merged, nil
+}
+
+// isDomainsConfigFragment returns whether the file with the given name would be read as a fragment of a
+// domains config directory: it must not be hidden, and must have a ".json", ".yaml", ".yml", or ".toml" extension
+// (optionally followed by EncryptedExt).
+func isDomainsConfigFragment(name string) bool {
+	if strings.HasPrefix(name, ".") {
+		return false
+	}
+	switch strings.ToLower(filepath.Ext(trimEncryptedExt(name))) {
+	case ".json", ".yaml", ".yml", ".toml":
+		return true
+	default:
+		return false
+	}
+}
@@ -0,0 +1,164 @@
+package app
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"do-ddns/server/secret"
+)
+
+// DefaultCredentialID identifies the credential formed by a domain's legacy Secret field.
+const DefaultCredentialID = "default"
+
+// Scopes which may be assigned to a credential to restrict what it can do.
+// A credential with no scopes may update both A and AAAA records, and may choose its IP
+// if the domain allows it.
+const (
+	ScopeAOnly            = "a-only"              // the credential may only update A records
+	ScopeAAAAOnly         = "aaaa-only"           // the credential may only update AAAA records
+	ScopeNoClientIPChoice = "no-client-ip-choice" // the credential may not choose its IP, even if the domain allows AllowClientIPChoice
+	ScopeReadStatus       = "read-status"         // the credential may only read the domain's status, and may not update it
+)
+
+// DeriveHMACSHA256 is the only supported Credential.Derive method. With it, the credential's secret is a key,
+// and each domain's secret is the hex-encoded HMAC-SHA256 of the normalized (lowercased, without any trailing dot)
+// domain name under that key.
+const DeriveHMACSHA256 = "hmac-sha256"
+
+var knownScopes = map[string]bool{
+	ScopeAOnly:            true,
+	ScopeAAAAOnly:         true,
+	ScopeNoClientIPChoice: true,
+	ScopeReadStatus:       true,
+}
+
+// Credential is a named secret which may be used to update a domain. A credential may be valid
+// only within a window of time, allowing secrets to be rotated without downtime.
+type Credential struct {
+	ID        string     `json:"id"`
+	Secret    string     `json:"secret"`              // the secret, in plaintext or as a bcrypt or argon2id hash
+	NotBefore *time.Time `json:"notBefore,omitempty"` // if set, the credential is not valid before this time
+	ExpiresAt *time.Time `json:"expiresAt,omitempty"` // if set, the credential is not valid at or after this time
+	Scopes    []string   `json:"scopes,omitempty"`    // restrictions on what the credential can do; see the Scope constants
+	Derive    string     `json:"derive,omitempty"`    // if set, Secret is a plaintext key from which each domain's secret is derived; see DeriveHMACSHA256
+}
+
+// HasScope returns whether the credential has been assigned the given scope.
+func (c Credential) HasScope(scope string) bool {
+	for _, s := range c.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// CanUpdate returns whether the credential may update records of the given type.
+func (c Credential) CanUpdate(recordType string) bool {
+	switch {
+	case c.HasScope(ScopeReadStatus):
+		return false
+	case c.HasScope(ScopeAOnly):
+		return recordType == "A"
+	case c.HasScope(ScopeAAAAOnly):
+		return recordType == "AAAA"
+	default:
+		return true
+	}
+}
+
+// CanChooseIP returns whether the credential may choose the IP its domain is updated to,
+// given that its domain allows AllowClientIPChoice.
+func (c Credential) CanChooseIP() bool {
+	return !c.HasScope(ScopeNoClientIPChoice)
+}
+
+// ValidAt returns whether the credential's validity window includes the given time.
+func (c Credential) ValidAt(t time.Time) bool {
+	if c.NotBefore != nil && t.Before(*c.NotBefore) {
+		return false
+	}
+	if c.ExpiresAt != nil && !t.Before(*c.ExpiresAt) {
+		return false
+	}
+	return true
+}
+
+// AllCredentials returns every credential configured for the domain, including the credential
+// formed by its Secret field (if set), which has the ID DefaultCredentialID.
+func (c DomainConfig) AllCredentials() []Credential {
+	retv := make([]Credential, 0, len(c.Credentials)+1)
+	if c.Secret != "" {
+		retv = append(retv, Credential{ID: DefaultCredentialID, Secret: c.Secret})
+	}
+	return append(retv, c.Credentials...)
+}
+
+// Authenticate finds the domain's credential which matches the given secret and is valid at the given time.
+// It returns false if no currently-valid credential matches.
+func (c DomainConfig) Authenticate(clientSecret string, now time.Time) (Credential, bool, error) {
+	for _, cred := range c.AllCredentials() {
+		stored := cred.Secret
+		if cred.Derive == DeriveHMACSHA256 {
+			stored = secret.DeriveHMACSHA256(cred.Secret, normalizeDomain(c.Domain))
+		}
+		ok, err := secret.Verify(stored, clientSecret)
+		if err != nil {
+			return Credential{}, false, fmt.Errorf("failed to verify credential '%s' for domain '%s': %w", cred.ID, c.Domain, err)
+		}
+		if !ok {
+			continue
+		}
+		if !cred.ValidAt(now) {
+			log.Printf("domain '%s': rejecting credential '%s', which is outside its validity window", c.Domain, cred.ID)
+			continue
+		}
+		return cred, true, nil
+	}
+	return Credential{}, false, nil
+}
+
+// validateCredentials checks that the domain has at least one credential, and that its credentials
+// have unique IDs, valid windows, and known scopes.
+func (c DomainConfig) validateCredentials() error {
+	creds := c.AllCredentials()
+	if len(creds) == 0 {
+		return errors.New("no secret or credentials are configured")
+	}
+	ids := make(map[string]bool)
+	for _, cred := range creds {
+		if cred.ID == "" {
+			return errors.New("a credential is missing its ID")
+		}
+		if ids[cred.ID] {
+			return fmt.Errorf("credential ID '%s' is used more than once", cred.ID)
+		}
+		ids[cred.ID] = true
+		if cred.Secret == "" {
+			return fmt.Errorf("credential '%s' has an empty secret", cred.ID)
+		}
+		if err := secret.Validate(cred.Secret); err != nil {
+			return fmt.Errorf("credential '%s' has an invalid secret: %w", cred.ID, err)
+		}
+		if cred.NotBefore != nil && cred.ExpiresAt != nil && !cred.ExpiresAt.After(*cred.NotBefore) {
+			return fmt.Errorf("credential '%s' expires before it becomes valid", cred.ID)
+		}
+		for _, scope := range cred.Scopes {
+			if !knownScopes[scope] {
+				return fmt.Errorf("credential '%s' has unknown scope '%s'", cred.ID, scope)
+			}
+		}
+		if cred.Derive != "" && cred.Derive != DeriveHMACSHA256 {
+			return fmt.Errorf("credential '%s' has unknown derive method '%s'", cred.ID, cred.Derive)
+		}
+		if cred.Derive != "" && secret.IsHashed(cred.Secret) {
+			return fmt.Errorf("credential '%s' derives secrets, so its secret must be a plaintext key rather than a hash", cred.ID)
+		}
+		if cred.HasScope(ScopeAOnly) && cred.HasScope(ScopeAAAAOnly) {
+			return fmt.Errorf("credential '%s' can't have both the '%s' and '%s' scopes", cred.ID, ScopeAOnly, ScopeAAAAOnly)
+		}
+	}
+	return nil
+}
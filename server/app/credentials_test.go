@@ -0,0 +1,41 @@
+package app
+
+import (
+	"testing"
+	"time"
+
+	"do-ddns/server/secret"
+)
+
+func TestAuthenticateDerived(t *testing.T) {
+	const key = "derivation key"
+	derived := secret.DeriveHMACSHA256(key, "home.example.org")
+
+	for _, domain := range []string{"home.example.org", "Home.Example.org", "home.example.org.", "HOME.example.org."} {
+		c := DomainConfig{
+			Domain:      domain,
+			Credentials: []Credential{{ID: "derived", Secret: key, Derive: DeriveHMACSHA256}},
+		}
+		cred, ok, err := c.Authenticate(derived, time.Now())
+		if err != nil || !ok || cred.ID != "derived" {
+			t.Errorf("%s: Authenticate(derived secret) = %q, %t, %v", domain, cred.ID, ok, err)
+		}
+		if _, ok, err := c.Authenticate(secret.DeriveHMACSHA256(key, "other.example.org"), time.Now()); ok || err != nil {
+			t.Errorf("%s: Authenticate(another host's secret) = %t, %v", domain, ok, err)
+		}
+	}
+}
+
+func TestIsDomain(t *testing.T) {
+	c := DomainConfig{Domain: "Home.Example.org."}
+	for _, domain := range []string{"home.example.org", "HOME.EXAMPLE.ORG", "home.example.org.", "Home.Example.org."} {
+		if !c.IsDomain(domain) {
+			t.Errorf("IsDomain(%q) = false", domain)
+		}
+	}
+	for _, domain := range []string{"example.org", "home.example.org..", "ahome.example.org", ""} {
+		if c.IsDomain(domain) {
+			t.Errorf("IsDomain(%q) = true", domain)
+		}
+	}
+}
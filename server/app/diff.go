@@ -0,0 +1,65 @@
+package app
+
+import (
+	"log"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// DomainsConfigDiff describes the domains added, removed, and changed between two domain configurations.
+type DomainsConfigDiff struct {
+	Added   []string
+	Removed []string
+	Changed []string
+}
+
+// Empty returns whether the two configurations configure the same domains identically.
+func (d DomainsConfigDiff) Empty() bool {
+	return len(d.Added) == 0 && len(d.Removed) == 0 && len(d.Changed) == 0
+}
+
+// DiffDomainsConfigs compares two domain configurations, by domain.
+func DiffDomainsConfigs(oldConfig *DomainsConfig, newConfig *DomainsConfig) DomainsConfigDiff {
+	oldDomains := make(map[string]DomainConfig)
+	for _, c := range oldConfig.Domains {
+		oldDomains[c.Domain] = c
+	}
+	newDomains := make(map[string]DomainConfig)
+	for _, c := range newConfig.Domains {
+		newDomains[c.Domain] = c
+	}
+
+	diff := DomainsConfigDiff{}
+	for domain, newDomain := range newDomains {
+		oldDomain, ok := oldDomains[domain]
+		if !ok {
+			diff.Added = append(diff.Added, domain)
+		} else if !reflect.DeepEqual(oldDomain, newDomain) {
+			diff.Changed = append(diff.Changed, domain)
+		}
+	}
+	for domain := range oldDomains {
+		if _, ok := newDomains[domain]; !ok {
+			diff.Removed = append(diff.Removed, domain)
+		}
+	}
+	sort.Strings(diff.Added)
+	sort.Strings(diff.Removed)
+	sort.Strings(diff.Changed)
+	return diff
+}
+
+// logDomainsConfigDiff logs the differences between the old and new configurations, returning them.
+func logDomainsConfigDiff(oldConfig *DomainsConfig, newConfig *DomainsConfig) DomainsConfigDiff {
+	diff := DiffDomainsConfigs(oldConfig, newConfig)
+	if diff.Empty() {
+		log.Println("reloaded domains config: no changes")
+		return diff
+	}
+	log.Printf("reloaded domains config: added [%s]; removed [%s]; changed [%s]",
+		strings.Join(diff.Added, ", "),
+		strings.Join(diff.Removed, ", "),
+		strings.Join(diff.Changed, ", "))
+	return diff
+}
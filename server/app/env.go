@@ -1,64 +1,212 @@
 package app
 
 import (
-	"encoding/json"
 	"fmt"
-	"io/ioutil"
+	"log"
+	"net"
+	"os"
+	"sort"
 	"sync"
 
 	"do-ddns/server/cache"
-	"do-ddns/server/digitalocean"
+	"do-ddns/server/configcrypt"
+	"do-ddns/server/provider"
 
 	"github.com/gorilla/schema"
 )
 
+// MinTTL is the shortest TTL, in seconds, which may be configured for a domain's records.
+const MinTTL = 30
+
+// DefaultProvider is the name of the DNS provider used for domains which don't specify one.
+const DefaultProvider = "digitalocean"
+
 // Env describes the application environment (configuration, shared API and cache, etc).
 type Env struct {
 	domainsConfig     *DomainsConfig
 	domainsConfigLock sync.RWMutex
-	DOAPI             *digitalocean.APIClient
+	DomainsConfigKey  *configcrypt.Key             // the key used to decrypt encrypted domains configuration files, if any
+	Providers         map[string]provider.Provider // DNS providers, keyed by DomainConfig.ProviderKey
+	TrustedProxies    []*net.IPNet                 // proxies whose forwarded-for headers are honored when determining a request's client IP
+	ForwardedHeader   string                       // the header trusted proxies identify the client with: ForwardedForHeader (if empty) or ForwardedHeader
 	UpdateCache       *cache.DNSUpdateCache
 	Decoder           *schema.Decoder
+	zoneLists         zoneListCache
+	addressHistory    addressHistory
 }
 
 // DomainsConfig is the schema for the configuration file listing domains that may be updated,
 // along with their secret keys.
 type DomainsConfig struct {
 	Domains []DomainConfig `json:"domains"`
+	entries []entrySource  // where each entry in Domains was defined, if known
 }
 
 // DomainConfig represents the configuration for a single domain.
 type DomainConfig struct {
-	Domain               string `json:"domain"`
-	Secret               string `json:"secret"`
-	AllowClientIPChoice  bool   `json:"allowClientIPChoice,omitEmpty"` // whether a client-provided IP can be respected, if using an endpoint which allows the client to choose a specific IP
-	CreateMissingRecords bool   `json:"createMissingRecords,omitEmpty"` // whether to create missing DNS records, rather than erroring, if no A/AAAA record exists to update
+	Domain               string       `json:"domain"`
+	Secret               string       `json:"secret"`
+	AllowClientIPChoice  bool         `json:"allowClientIPChoice,omitempty"`  // whether a client-provided IP can be respected, if using an endpoint which allows the client to choose a specific IP
+	CreateMissingRecords bool         `json:"createMissingRecords,omitempty"` // whether to create missing DNS records, rather than erroring, if no A/AAAA record exists to update
+	Provider             string       `json:"provider,omitempty"`             // the name of the DNS provider hosting this domain; defaults to DefaultProvider
+	Account              string       `json:"account,omitempty"`              // the named account at the DNS provider which hosts this domain; if empty, the provider's default account is used
+	TTL                  int          `json:"ttl,omitempty"`                  // the TTL, in seconds, for this domain's records when they're created or updated; if 0, existing TTLs are kept and new records get the provider's default
+	AdaptiveTTL          *AdaptiveTTL `json:"adaptiveTTL,omitempty"`          // if set, the TTL is lowered after the domain's address changes and raised as it stays stable, instead of using TTL
+	Zone                 string       `json:"zone,omitempty"`                 // the zone containing this domain; if empty, it's found by searching the zones hosted at the domain's provider
+	Credentials          []Credential `json:"credentials,omitempty"`          // named credentials which may be used to update this domain, in addition to Secret
+
+	// MatchedPattern is set to the pattern entry's domain (eg. "*.lab.example.org") when this configuration
+	// was found by matching a domain against a pattern entry.
+	MatchedPattern string `json:"-"`
+}
+
+// ProviderName returns the name of the DNS provider hosting this domain.
+func (c DomainConfig) ProviderName() string {
+	if c.Provider == "" {
+		return DefaultProvider
+	}
+	return c.Provider
 }
 
-// DomainConfig looks up the configuration for the given domain name.
+// ProviderKey identifies the DNS provider account hosting this domain, as a key into Env.Providers: the provider's
+// name for its default account, or "provider/account" for a named account (eg. "digitalocean/team").
+func (c DomainConfig) ProviderKey() string {
+	return ProviderKey(c.ProviderName(), c.Account)
+}
+
+// ProviderKey returns the key into Env.Providers for the given provider and account name.
+// An empty account name refers to the provider's default account.
+func ProviderKey(providerName string, account string) string {
+	if account == "" {
+		return providerName
+	}
+	return providerName + "/" + account
+}
+
+// IsDomain returns whether this configuration's domain is the given domain name, ignoring case and any
+// trailing dot.
+func (c DomainConfig) IsDomain(domain string) bool {
+	return normalizeDomain(c.Domain) == normalizeDomain(domain)
+}
+
+// DomainConfig looks up the configuration for the given domain name, ignoring case and any trailing dot.
+// An entry for exactly the given domain takes precedence over any pattern entries (eg. "*.lab.example.org")
+// which match it. When a pattern entry matches, the returned configuration's Domain is the given domain.
 func (e *Env) DomainConfig(domain string) (DomainConfig, bool) {
 	e.domainsConfigLock.RLock()
 	defer e.domainsConfigLock.RUnlock()
 	for _, v := range e.domainsConfig.Domains {
-		if v.Domain == domain {
+		if !v.IsPattern() && v.IsDomain(domain) {
 			return v, true
 		}
 	}
+	for _, v := range e.domainsConfig.Domains {
+		if v.IsPattern() && matchPattern(v.Domain, domain) {
+			return v.forDomain(domain), true
+		}
+	}
 	return DomainConfig{}, false
 }
 
+// Provider returns the DNS provider which hosts the given domain.
+func (e *Env) Provider(c DomainConfig) (provider.Provider, error) {
+	p, ok := e.Providers[c.ProviderKey()]
+	if !ok {
+		if c.Account != "" {
+			return nil, fmt.Errorf("account '%s' at DNS provider '%s' for domain '%s' is not configured", c.Account, c.ProviderName(), c.Domain)
+		}
+		return nil, fmt.Errorf("DNS provider '%s' for domain '%s' is not configured", c.ProviderName(), c.Domain)
+	}
+	return p, nil
+}
+
 // ReadDomainsConfig updates the environment's domain configuration, reading it from the given path.
+// The new configuration is validated fully before it replaces the current configuration, and the
+// differences between the two are logged.
 func (e *Env) ReadDomainsConfig(configPath string) error {
+	domainsConfig, err := e.LoadDomainsConfig(configPath)
+	if err != nil {
+		return err
+	}
+
+	for _, warning := range e.DomainsConfigWarnings(domainsConfig) {
+		log.Printf("warning: %s", warning.Error())
+	}
+
 	e.domainsConfigLock.Lock()
-	defer e.domainsConfigLock.Unlock()
+	oldConfig := e.domainsConfig
+	e.domainsConfig = domainsConfig
+	e.domainsConfigLock.Unlock()
+
+	if oldConfig != nil {
+		// cached updates may not reflect the new config (eg. a changed TTL or zone), so they must be redone
+		if diff := logDomainsConfigDiff(oldConfig, domainsConfig); !diff.Empty() && e.UpdateCache != nil {
+			e.UpdateCache.Clear()
+		}
+	}
+	return nil
+}
+
+// DomainsConfigWarnings returns problems with the given configuration which don't prevent it from being used,
+// like a TTL shorter than the update cache's lifetime.
+func (e *Env) DomainsConfigWarnings(domainsConfig *DomainsConfig) ConfigErrors {
+	cacheLifetime := cache.DefaultLifetime
+	if e.UpdateCache != nil && e.UpdateCache.Lifetime != 0 {
+		cacheLifetime = e.UpdateCache.Lifetime
+	}
+	return domainsConfig.warnings(cacheLifetime)
+}
 
-	configFile, err := ioutil.ReadFile(configPath)
+// LoadDomainsConfig reads and validates the domain configuration at the given path, without
+// changing the environment's current configuration. The path may be a single file, or a directory
+// of fragments which are merged into one configuration (see readDomainsConfigDir).
+func (e *Env) LoadDomainsConfig(configPath string) (*DomainsConfig, error) {
+	info, err := os.Stat(configPath)
 	if err != nil {
-		return fmt.Errorf("couldn't read config file '%s': %w", configPath, err)
+		return nil, fmt.Errorf("couldn't read config file '%s': %w", configPath, err)
+	}
+	var domainsConfig *DomainsConfig
+	if info.IsDir() {
+		domainsConfig, err = e.readDomainsConfigDir(configPath)
+	} else {
+		domainsConfig, err = e.readDomainsConfigFile(configPath)
 	}
-	err = json.Unmarshal(configFile, &e.domainsConfig)
 	if err != nil {
-		return fmt.Errorf("couldn't parse config file '%s' as JSON: %w", configPath, err)
+		return nil, err
 	}
-	return nil
+	if errs := e.validateDomainsConfig(domainsConfig); len(errs) > 0 {
+		return nil, fmt.Errorf("invalid config file '%s': %w", configPath, errs)
+	}
+	return domainsConfig, nil
+}
+
+// ParseDomainsConfig decodes and validates a plaintext domains configuration in the given format
+// (see DomainsConfigFormat).
+func (e *Env) ParseDomainsConfig(data []byte, format string) (*DomainsConfig, error) {
+	domainsConfig, err := decodeDomainsConfig(data, format)
+	if err != nil {
+		return nil, err
+	}
+	if errs := e.validateDomainsConfig(domainsConfig); len(errs) > 0 {
+		return nil, errs
+	}
+	return domainsConfig, nil
+}
+
+// validateDomainsConfig checks the semantics of the configuration, including whether each domain's provider
+// is configured, returning every problem found in the order in which they appear in the configuration.
+func (e *Env) validateDomainsConfig(domainsConfig *DomainsConfig) ConfigErrors {
+	errs := domainsConfig.validate()
+	for i, c := range domainsConfig.Domains {
+		if _, err := e.Provider(c); err != nil {
+			errs = append(errs, domainsConfig.configError(i, err))
+		}
+	}
+	sort.SliceStable(errs, func(i, j int) bool {
+		if errs[i].File != errs[j].File {
+			return errs[i].File < errs[j].File
+		}
+		return errs[i].Index < errs[j].Index
+	})
+	return errs
 }
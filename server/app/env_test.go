@@ -0,0 +1,43 @@
+package app
+
+import "testing"
+
+func TestDomainConfigPrecedence(t *testing.T) {
+	e := &Env{domainsConfig: &DomainsConfig{Domains: []DomainConfig{
+		{Domain: "*.lab.example.org", Secret: "pattern"},
+		{Domain: "host.lab.example.org", Secret: "exact"},
+		{Domain: "Other.Example.org.", Secret: "other"},
+	}}}
+
+	tests := []struct {
+		domain      string
+		wantOK      bool
+		wantSecret  string
+		wantDomain  string
+		wantPattern string
+	}{
+		{domain: "host.lab.example.org", wantOK: true, wantSecret: "exact", wantDomain: "host.lab.example.org"},
+		{domain: "HOST.lab.example.org", wantOK: true, wantSecret: "exact", wantDomain: "host.lab.example.org"},
+		{domain: "host.lab.example.org.", wantOK: true, wantSecret: "exact", wantDomain: "host.lab.example.org"},
+		{domain: "other.example.org", wantOK: true, wantSecret: "other", wantDomain: "Other.Example.org."},
+		{domain: "nas.lab.example.org", wantOK: true, wantSecret: "pattern", wantDomain: "nas.lab.example.org", wantPattern: "*.lab.example.org"},
+		{domain: "NAS.lab.example.org.", wantOK: true, wantSecret: "pattern", wantDomain: "nas.lab.example.org", wantPattern: "*.lab.example.org"},
+		{domain: "lab.example.org", wantOK: false},
+		{domain: "a.nas.lab.example.org", wantOK: false},
+		{domain: "*.lab.example.org", wantOK: false},
+	}
+	for _, tt := range tests {
+		c, ok := e.DomainConfig(tt.domain)
+		if ok != tt.wantOK {
+			t.Errorf("DomainConfig(%q): found = %t, want %t", tt.domain, ok, tt.wantOK)
+			continue
+		}
+		if !ok {
+			continue
+		}
+		if c.Secret != tt.wantSecret || c.Domain != tt.wantDomain || c.MatchedPattern != tt.wantPattern {
+			t.Errorf("DomainConfig(%q) = {Domain: %q, Secret: %q, MatchedPattern: %q}, want {%q, %q, %q}",
+				tt.domain, c.Domain, c.Secret, c.MatchedPattern, tt.wantDomain, tt.wantSecret, tt.wantPattern)
+		}
+	}
+}
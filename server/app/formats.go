@@ -0,0 +1,139 @@
+package app
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/pelletier/go-toml"
+	"gopkg.in/yaml.v3"
+)
+
+// Supported domains configuration file formats.
+const (
+	FormatJSON = "json"
+	FormatYAML = "yaml"
+	FormatTOML = "toml"
+)
+
+// EncryptedExt may follow the usual extension of an encrypted domains configuration file (eg. "domains.yaml.enc").
+const EncryptedExt = ".enc"
+
+// DomainsConfigFormat returns the format of the domains configuration file at the given path, based on its
+// extension: ".yaml" or ".yml" for YAML, ".toml" for TOML, and JSON for anything else. EncryptedExt is ignored.
+func DomainsConfigFormat(configPath string) string {
+	switch strings.ToLower(filepath.Ext(trimEncryptedExt(configPath))) {
+	case ".yaml", ".yml":
+		return FormatYAML
+	case ".toml":
+		return FormatTOML
+	default:
+		return FormatJSON
+	}
+}
+
+// trimEncryptedExt removes EncryptedExt from the end of the given path, if present.
+func trimEncryptedExt(configPath string) string {
+	if strings.EqualFold(filepath.Ext(configPath), EncryptedExt) {
+		return configPath[:len(configPath)-len(EncryptedExt)]
+	}
+	return configPath
+}
+
+// decodeDomainsConfig strictly decodes a domains configuration in the given format, recording the line on
+// which each domain entry begins. Every format is decoded into the same DomainsConfig structure, subject
+// to the same rules: keys must exactly match the expected field names.
+func decodeDomainsConfig(data []byte, format string) (*DomainsConfig, error) {
+	switch format {
+	case FormatJSON:
+		return decodeJSONDomainsConfig(data)
+	case FormatYAML:
+		return decodeYAMLDomainsConfig(data)
+	case FormatTOML:
+		return decodeTOMLDomainsConfig(data)
+	default:
+		return nil, fmt.Errorf("unsupported config format '%s'", format)
+	}
+}
+
+// decodeYAMLDomainsConfig strictly decodes a YAML domains configuration.
+func decodeYAMLDomainsConfig(data []byte) (*DomainsConfig, error) {
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, ConfigError{Index: -1, Err: err}
+	}
+	if len(doc.Content) == 0 {
+		return nil, ConfigError{Index: -1, Err: errors.New("configuration is empty")}
+	}
+	root := doc.Content[0]
+	if root.Kind != yaml.MappingNode {
+		return nil, ConfigError{Line: root.Line, Index: -1, Err: errors.New("expected a mapping at the top level")}
+	}
+
+	domainsConfig := &DomainsConfig{}
+	sawDomains := false
+	for i := 0; i+1 < len(root.Content); i += 2 {
+		key, value := root.Content[i], root.Content[i+1]
+		if key.Value != "domains" || sawDomains {
+			return nil, ConfigError{Line: key.Line, Index: -1, Err: fmt.Errorf("unexpected field '%s'", key.Value)}
+		}
+		sawDomains = true
+
+		if value.Kind == yaml.ScalarNode && value.Tag == "!!null" {
+			continue
+		}
+		if value.Kind != yaml.SequenceNode {
+			return nil, ConfigError{Line: value.Line, Index: -1, Err: errors.New("expected a list of domains")}
+		}
+		for j, item := range value.Content {
+			var entry interface{}
+			if err := item.Decode(&entry); err != nil {
+				return nil, ConfigError{Line: item.Line, Index: j, Err: err}
+			}
+			domainConfig, err := decodeDomainConfig(entry)
+			if err != nil {
+				return nil, ConfigError{Line: item.Line, Index: j, Domain: domainConfig.Domain, Err: err}
+			}
+			domainsConfig.Domains = append(domainsConfig.Domains, domainConfig)
+			domainsConfig.entries = append(domainsConfig.entries, entrySource{Line: item.Line, Index: j})
+		}
+	}
+	return domainsConfig, nil
+}
+
+// decodeTOMLDomainsConfig strictly decodes a TOML domains configuration, in which each domain is
+// given as a [[domains]] table.
+func decodeTOMLDomainsConfig(data []byte) (*DomainsConfig, error) {
+	tree, err := toml.LoadBytes(data)
+	if err != nil {
+		return nil, ConfigError{Index: -1, Err: err}
+	}
+
+	keys := tree.Keys()
+	sort.Strings(keys)
+	for _, key := range keys {
+		if key != "domains" {
+			return nil, ConfigError{Line: tree.GetPosition(key).Line, Index: -1, Err: fmt.Errorf("unexpected field '%s'", key)}
+		}
+	}
+
+	domainsConfig := &DomainsConfig{}
+	switch domains := tree.Get("domains").(type) {
+	case nil:
+	case []*toml.Tree:
+		for i, item := range domains {
+			line := item.Position().Line
+			domainConfig, err := decodeDomainConfig(item.ToMap())
+			if err != nil {
+				return nil, ConfigError{Line: line, Index: i, Domain: domainConfig.Domain, Err: err}
+			}
+			domainsConfig.Domains = append(domainsConfig.Domains, domainConfig)
+			domainsConfig.entries = append(domainsConfig.entries, entrySource{Line: line, Index: i})
+		}
+	default:
+		return nil, ConfigError{Line: tree.GetPosition("domains").Line, Index: -1, Err: errors.New("expected an array of domain tables")}
+	}
+	return domainsConfig, nil
+}
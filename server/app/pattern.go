@@ -0,0 +1,64 @@
+package app
+
+import (
+	"fmt"
+	"strings"
+)
+
+// wildcardPrefix begins a pattern domain entry, such as "*.lab.example.org".
+const wildcardPrefix = "*."
+
+// IsPattern returns whether this configuration is a pattern entry, such as "*.lab.example.org",
+// rather than the configuration for a single domain.
+func (c DomainConfig) IsPattern() bool {
+	return strings.HasPrefix(c.Domain, wildcardPrefix)
+}
+
+// matchPattern returns whether the given domain matches the given pattern entry's domain.
+// A wildcard matches exactly one DNS label, so "*.lab.example.org" matches "host.lab.example.org"
+// but neither "lab.example.org" nor "a.host.lab.example.org".
+func matchPattern(pattern string, domain string) bool {
+	suffix := normalizeDomain(strings.TrimPrefix(pattern, "*"))
+	domain = normalizeDomain(domain)
+	if !strings.HasSuffix(domain, suffix) {
+		return false
+	}
+	return isValidLabel(strings.TrimSuffix(domain, suffix))
+}
+
+// forDomain returns a copy of this pattern entry's configuration, for the given matching domain.
+func (c DomainConfig) forDomain(domain string) DomainConfig {
+	c.MatchedPattern = c.Domain
+	c.Domain = normalizeDomain(domain)
+	return c
+}
+
+// isValidLabel returns whether the given string is a valid hostname label:
+// 1-63 letters, digits, and hyphens, not beginning or ending with a hyphen.
+func isValidLabel(label string) bool {
+	if len(label) == 0 || len(label) > 63 || label[0] == '-' || label[len(label)-1] == '-' {
+		return false
+	}
+	for _, r := range label {
+		if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '-') {
+			return false
+		}
+	}
+	return true
+}
+
+// validatePattern checks that a pattern entry has a single wildcard, as its leftmost label,
+// followed by a valid hostname with at least two labels.
+func (c DomainConfig) validatePattern() error {
+	suffix := strings.TrimPrefix(c.Domain, wildcardPrefix)
+	if strings.Contains(suffix, "*") {
+		return fmt.Errorf("pattern '%s' may only contain a wildcard as its leftmost label", c.Domain)
+	}
+	if !strings.Contains(strings.TrimSuffix(suffix, "."), ".") {
+		return fmt.Errorf("pattern '%s' is too broad", c.Domain)
+	}
+	if !isValidHostname(suffix) {
+		return fmt.Errorf("pattern '%s' is not a wildcard followed by a valid hostname", c.Domain)
+	}
+	return nil
+}
@@ -0,0 +1,65 @@
+package app
+
+import (
+	"fmt"
+	"net"
+	"strings"
+)
+
+// Forwarding headers which may identify a request's client, when it comes from a trusted proxy.
+const (
+	ForwardedForHeader = "x-forwarded-for"
+	ForwardedHeader    = "forwarded" // RFC 7239
+)
+
+// ParseCIDRs parses a comma-separated list of CIDR blocks, such as "127.0.0.0/8, ::1/128".
+// Bare IP addresses are accepted, and are treated as single-host blocks.
+func ParseCIDRs(s string) ([]*net.IPNet, error) {
+	retv := make([]*net.IPNet, 0)
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		if !strings.Contains(part, "/") {
+			ip := net.ParseIP(part)
+			if ip == nil {
+				return nil, fmt.Errorf("cannot parse IP '%s'", part)
+			}
+			bits := 8 * net.IPv6len
+			if ip4 := ip.To4(); ip4 != nil {
+				ip, bits = ip4, 8*net.IPv4len
+			}
+			retv = append(retv, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, ipNet, err := net.ParseCIDR(part)
+		if err != nil {
+			return nil, fmt.Errorf("cannot parse CIDR '%s': %w", part, err)
+		}
+		retv = append(retv, ipNet)
+	}
+	return retv, nil
+}
+
+// ClientIPHeader returns the forwarding header which identifies a request's client, when it comes from
+// a trusted proxy: ForwardedForHeader, unless the environment specifies otherwise.
+func (e *Env) ClientIPHeader() string {
+	if e.ForwardedHeader == "" {
+		return ForwardedForHeader
+	}
+	return e.ForwardedHeader
+}
+
+// IsTrustedProxy returns whether the given IP is within any of the environment's trusted proxy CIDR blocks.
+func (e *Env) IsTrustedProxy(ip net.IP) bool {
+	if ip == nil {
+		return false
+	}
+	for _, ipNet := range e.TrustedProxies {
+		if ipNet.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,370 @@
+package app
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ConfigError describes a problem with the domains configuration, optionally pinpointing the domain entry
+// which caused it.
+type ConfigError struct {
+	File   string // the file containing the problem, if the configuration was read from multiple files
+	Line   int    // the line on which the problematic entry begins, or 0 if unknown
+	Index  int    // the index of the problematic entry in the domains list, or -1 if the problem isn't specific to an entry
+	Domain string // the problematic entry's domain, if known
+	Err    error
+}
+
+// Error conforms ConfigError to the Golang error interface.
+func (e ConfigError) Error() string {
+	var location []string
+	if e.File != "" {
+		location = append(location, e.File)
+	}
+	if e.Line > 0 {
+		location = append(location, fmt.Sprintf("line %d", e.Line))
+	}
+	if e.Index >= 0 {
+		entry := fmt.Sprintf("domains[%d]", e.Index)
+		if e.Domain != "" {
+			entry += fmt.Sprintf(" ('%s')", e.Domain)
+		}
+		location = append(location, entry)
+	}
+	if len(location) == 0 {
+		return e.Err.Error()
+	}
+	return fmt.Sprintf("%s: %s", strings.Join(location, ": "), e.Err.Error())
+}
+
+// Unwrap allows ConfigError to satisfy the Golang 1.13 error interface.
+func (e ConfigError) Unwrap() error {
+	return e.Err
+}
+
+// ConfigErrors collects every problem found while validating a domains configuration.
+type ConfigErrors []ConfigError
+
+// Error conforms ConfigErrors to the Golang error interface.
+func (e ConfigErrors) Error() string {
+	msgs := make([]string, len(e))
+	for i, err := range e {
+		msgs[i] = err.Error()
+	}
+	if len(msgs) == 1 {
+		return msgs[0]
+	}
+	return fmt.Sprintf("%d problems: %s", len(msgs), strings.Join(msgs, "; "))
+}
+
+// decodeJSONDomainsConfig strictly decodes a JSON domains configuration, recording the line on which
+// each domain entry begins. Unknown keys are rejected; unlike encoding/json's default behavior, keys must
+// match the expected field names exactly, including capitalization.
+func decodeJSONDomainsConfig(data []byte) (*DomainsConfig, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	syntaxError := func(err error) error {
+		var syntaxErr *json.SyntaxError
+		if errors.As(err, &syntaxErr) {
+			return ConfigError{Line: lineAt(data, syntaxErr.Offset), Index: -1, Err: err}
+		}
+		return ConfigError{Line: lineAt(data, dec.InputOffset()), Index: -1, Err: err}
+	}
+	expectDelim := func(want json.Delim) error {
+		tok, err := dec.Token()
+		if err != nil {
+			return syntaxError(err)
+		}
+		if tok != want {
+			return ConfigError{Line: lineAt(data, dec.InputOffset()), Index: -1, Err: fmt.Errorf("expected '%s' but found '%v'", want, tok)}
+		}
+		return nil
+	}
+
+	domainsConfig := &DomainsConfig{}
+	if err := expectDelim('{'); err != nil {
+		return nil, err
+	}
+	sawDomains := false
+	for dec.More() {
+		tok, err := dec.Token()
+		if err != nil {
+			return nil, syntaxError(err)
+		}
+		if key, _ := tok.(string); key != "domains" || sawDomains {
+			return nil, ConfigError{Line: lineAt(data, dec.InputOffset()), Index: -1, Err: fmt.Errorf("unexpected field '%v'", tok)}
+		}
+		sawDomains = true
+
+		if err := expectDelim('['); err != nil {
+			return nil, err
+		}
+		for i := 0; dec.More(); i++ {
+			line := lineAt(data, dec.InputOffset())
+			var entry interface{}
+			if err := dec.Decode(&entry); err != nil {
+				return nil, syntaxError(err)
+			}
+			domainConfig, err := decodeDomainConfig(entry)
+			if err != nil {
+				return nil, ConfigError{Line: line, Index: i, Domain: domainConfig.Domain, Err: err}
+			}
+			domainsConfig.Domains = append(domainsConfig.Domains, domainConfig)
+			domainsConfig.entries = append(domainsConfig.entries, entrySource{Line: line, Index: i})
+		}
+		if err := expectDelim(']'); err != nil {
+			return nil, err
+		}
+	}
+	if err := expectDelim('}'); err != nil {
+		return nil, err
+	}
+	if _, err := dec.Token(); err != io.EOF {
+		return nil, ConfigError{Line: lineAt(data, dec.InputOffset()), Index: -1, Err: errors.New("unexpected data after the end of the configuration")}
+	}
+	return domainsConfig, nil
+}
+
+// decodeDomainConfig strictly decodes a single domain entry from its generic representation
+// (as produced by decoding into an interface{}).
+func decodeDomainConfig(entry interface{}) (DomainConfig, error) {
+	domainConfig := DomainConfig{}
+	if obj, ok := entry.(map[string]interface{}); ok {
+		domainConfig.Domain, _ = obj["domain"].(string)
+	}
+	if err := checkKeys(entry, reflect.TypeOf(domainConfig), ""); err != nil {
+		return domainConfig, err
+	}
+	entryJSON, err := json.Marshal(entry)
+	if err != nil {
+		return domainConfig, err
+	}
+	dec := json.NewDecoder(bytes.NewReader(entryJSON))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&domainConfig); err != nil {
+		return domainConfig, err
+	}
+	return domainConfig, nil
+}
+
+var jsonUnmarshalerType = reflect.TypeOf((*json.Unmarshaler)(nil)).Elem()
+
+// checkKeys returns an error if the given generic value, or any object nested within it, contains a key
+// which doesn't exactly match the JSON name of a field in the corresponding struct type.
+// Type mismatches are left to be reported by the decoder.
+func checkKeys(v interface{}, t reflect.Type, path string) error {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	switch t.Kind() {
+	case reflect.Slice:
+		items, _ := v.([]interface{})
+		for i, item := range items {
+			if err := checkKeys(item, t.Elem(), fmt.Sprintf("%s[%d]", path, i)); err != nil {
+				return err
+			}
+		}
+	case reflect.Struct:
+		obj, ok := v.(map[string]interface{})
+		if !ok || reflect.PtrTo(t).Implements(jsonUnmarshalerType) {
+			return nil
+		}
+		fields := jsonFields(t)
+		keys := make([]string, 0, len(obj))
+		for k := range obj {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			fieldPath := k
+			if path != "" {
+				fieldPath = path + "." + k
+			}
+			fieldType, ok := fields[k]
+			if !ok {
+				for name := range fields {
+					if strings.EqualFold(name, k) {
+						return fmt.Errorf("unknown field '%s' (did you mean '%s'?)", fieldPath, name)
+					}
+				}
+				return fmt.Errorf("unknown field '%s'", fieldPath)
+			}
+			if err := checkKeys(obj[k], fieldType, fieldPath); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// jsonFields maps the JSON names of the given struct type's fields to their types.
+func jsonFields(t reflect.Type) map[string]reflect.Type {
+	fields := make(map[string]reflect.Type)
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.PkgPath != "" {
+			continue // unexported
+		}
+		name := strings.Split(f.Tag.Get("json"), ",")[0]
+		if name == "-" {
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		fields[name] = f.Type
+	}
+	return fields
+}
+
+// lineAt returns the 1-based line number of the first non-separator character at or after the given offset.
+func lineAt(data []byte, offset int64) int {
+	if offset > int64(len(data)) {
+		offset = int64(len(data))
+	}
+	for offset < int64(len(data)) && strings.IndexByte(" \t\r\n,:", data[offset]) >= 0 {
+		offset++
+	}
+	return bytes.Count(data[:offset], []byte("\n")) + 1
+}
+
+// validate checks the semantics of the configuration, returning every problem found. Whether each domain's
+// provider is configured is checked separately, by Env.validateDomainsConfig.
+func (c *DomainsConfig) validate() ConfigErrors {
+	var errs ConfigErrors
+	fail := func(i int, err error) {
+		errs = append(errs, c.configError(i, err))
+	}
+
+	seen := make(map[string]int)
+	for i, d := range c.Domains {
+		if d.Domain == "" {
+			fail(i, errors.New("domain is missing"))
+			continue
+		}
+		if d.IsPattern() {
+			if err := d.validatePattern(); err != nil {
+				fail(i, err)
+			}
+		} else if !isValidHostname(d.Domain) {
+			fail(i, fmt.Errorf("'%s' is not a valid hostname", d.Domain))
+		}
+
+		key := normalizeDomain(d.Domain)
+		if first, ok := seen[key]; ok {
+			fail(i, fmt.Errorf("domain is already configured at %s", c.source(first)))
+		} else {
+			seen[key] = i
+		}
+
+		if err := d.validateCredentials(); err != nil {
+			fail(i, err)
+		}
+
+		if d.TTL != 0 && d.TTL < MinTTL {
+			fail(i, fmt.Errorf("ttl must be at least %d seconds (got %d)", MinTTL, d.TTL))
+		}
+		if d.AdaptiveTTL != nil {
+			if d.TTL != 0 {
+				fail(i, errors.New("ttl and adaptiveTTL can't both be set"))
+			}
+			if err := d.AdaptiveTTL.validate(); err != nil {
+				fail(i, err)
+			}
+		}
+
+		if d.Zone != "" {
+			if !isValidHostname(d.Zone) {
+				fail(i, fmt.Errorf("zone '%s' is not a valid hostname", d.Zone))
+			} else if _, ok := relativeName(normalizeDomain(d.Domain), normalizeDomain(d.Zone)); !ok {
+				fail(i, fmt.Errorf("domain is not within its configured zone '%s'", d.Zone))
+			}
+		}
+	}
+	return errs
+}
+
+// warnings returns problems with the configuration which don't prevent it from being used, given the lifetime of
+// the server's update cache.
+func (c *DomainsConfig) warnings(cacheLifetime time.Duration) ConfigErrors {
+	var warnings ConfigErrors
+	for i, d := range c.Domains {
+		// an adaptive TTL is at its shortest right after the address changes
+		field, seconds := "ttl", d.TTL
+		if d.AdaptiveTTL != nil {
+			field, seconds = "adaptiveTTL.min", d.AdaptiveTTL.Min
+		}
+		if ttl := time.Duration(seconds) * time.Second; ttl > 0 && ttl < cacheLifetime {
+			warnings = append(warnings, c.configError(i, fmt.Errorf(
+				"%s (%s) is shorter than the update cache lifetime (%s); records changed outside do-ddns may go uncorrected for longer than the TTL",
+				field, ttl, cacheLifetime)))
+		}
+	}
+	return warnings
+}
+
+// entrySource describes where a domain entry was defined.
+type entrySource struct {
+	File  string // the file containing the entry, if the configuration was read from multiple files
+	Line  int    // the line on which the entry begins, or 0 if unknown
+	Index int    // the index of the entry in its file's domains list
+}
+
+// String describes the entry's location, eg. "site-a.json: line 3".
+func (s entrySource) String() string {
+	var location []string
+	if s.File != "" {
+		location = append(location, s.File)
+	}
+	if s.Line > 0 {
+		location = append(location, fmt.Sprintf("line %d", s.Line))
+	} else {
+		location = append(location, fmt.Sprintf("domains[%d]", s.Index))
+	}
+	return strings.Join(location, ": ")
+}
+
+// source returns where the i'th domain entry was defined.
+func (c *DomainsConfig) source(i int) entrySource {
+	if i < len(c.entries) {
+		return c.entries[i]
+	}
+	return entrySource{Index: i}
+}
+
+// configError returns a ConfigError describing the given problem with the i'th domain entry.
+func (c *DomainsConfig) configError(i int, err error) ConfigError {
+	source := c.source(i)
+	return ConfigError{
+		File:   source.File,
+		Line:   source.Line,
+		Index:  source.Index,
+		Domain: c.Domains[i].Domain,
+		Err:    err,
+	}
+}
+
+// isValidHostname returns whether the given name is a valid hostname with at least two labels.
+func isValidHostname(name string) bool {
+	name = strings.TrimSuffix(name, ".")
+	if len(name) > 253 {
+		return false
+	}
+	labels := strings.Split(name, ".")
+	if len(labels) < 2 {
+		return false
+	}
+	for _, label := range labels {
+		if !isValidLabel(label) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,211 @@
+package app
+
+import (
+	"testing"
+	"time"
+
+	"do-ddns/server/provider"
+)
+
+func TestParseDomainsConfig(t *testing.T) {
+	tests := []struct {
+		name    string
+		format  string
+		data    string
+		wantErr string
+	}{
+		{name: "valid", format: FormatJSON, data: `{
+  "domains": [
+    {"domain": "a.example.org", "secret": "s1"},
+    {
+      "domain": "b.example.org",
+      "secret": "s2",
+      "allowClientIPChoice": true,
+      "adaptiveTTL": {"min": 60, "max": 3600}
+    }
+  ]
+}`},
+		{name: "miscapitalized key", format: FormatJSON, data: `{
+  "domains": [
+    {"domain": "a.example.org", "secret": "s1"},
+    {
+      "domain": "b.example.org",
+      "secret": "s2",
+      "allowClientIpChoice": true
+    }
+  ]
+}`, wantErr: "line 4: domains[1] ('b.example.org'): unknown field 'allowClientIpChoice' (did you mean 'allowClientIPChoice'?)"},
+		{name: "unknown nested key", format: FormatJSON, data: `{
+  "domains": [
+    {"domain": "a.example.org", "secret": "s1"},
+    {
+      "domain": "b.example.org",
+      "secret": "s2",
+      "adaptiveTTL": {"min": 60, "maximum": 3600}
+    }
+  ]
+}`, wantErr: "line 4: domains[1] ('b.example.org'): unknown field 'adaptiveTTL.maximum'"},
+		{name: "duplicate entry", format: FormatJSON, data: `{
+  "domains": [
+    {"domain": "a.example.org", "secret": "s1"},
+    {
+      "domain": "A.Example.org.",
+      "secret": "s2"
+    }
+  ]
+}`, wantErr: "line 4: domains[1] ('A.Example.org.'): domain is already configured at line 3"},
+		{name: "unknown top-level key", format: FormatJSON, data: `{
+  "domain": []
+}`, wantErr: "line 2: unexpected field 'domain'"},
+		{name: "syntax error", format: FormatJSON, data: `{
+  "domains": [
+    {"domain": "a.example.org", "secret": "s1"},
+    {
+      "domain": "b.example.org"
+      "secret": "s2"
+    }
+  ]
+}`, wantErr: "line 6: invalid character '\"' after object key:value pair"},
+
+		{name: "valid", format: FormatYAML, data: `
+domains:
+  - domain: a.example.org
+    secret: s1
+  - domain: b.example.org
+    secret: s2
+    allowClientIPChoice: true
+    adaptiveTTL: {min: 60, max: 3600}
+`},
+		{name: "miscapitalized key", format: FormatYAML, data: `
+domains:
+  - domain: a.example.org
+    secret: s1
+  - domain: b.example.org
+    secret: s2
+    allowClientIpChoice: true
+`, wantErr: "line 5: domains[1] ('b.example.org'): unknown field 'allowClientIpChoice' (did you mean 'allowClientIPChoice'?)"},
+		{name: "unknown nested key", format: FormatYAML, data: `
+domains:
+  - domain: a.example.org
+    secret: s1
+  - domain: b.example.org
+    secret: s2
+    adaptiveTTL: {min: 60, maximum: 3600}
+`, wantErr: "line 5: domains[1] ('b.example.org'): unknown field 'adaptiveTTL.maximum'"},
+		{name: "duplicate entry", format: FormatYAML, data: `
+domains:
+  - domain: a.example.org
+    secret: s1
+  - domain: A.Example.org.
+    secret: s2
+`, wantErr: "line 5: domains[1] ('A.Example.org.'): domain is already configured at line 3"},
+		{name: "unknown top-level key", format: FormatYAML, data: `
+domain: []
+`, wantErr: "line 2: unexpected field 'domain'"},
+		{name: "syntax error", format: FormatYAML, data: `
+domains:
+  - domain: a.example.org
+    secret: s1
+  - domain: b.example.org
+    secret: s2: s3
+`, wantErr: "yaml: line 6: mapping values are not allowed in this context"},
+
+		{name: "valid", format: FormatTOML, data: `
+[[domains]]
+domain = "a.example.org"
+secret = "s1"
+
+[[domains]]
+domain = "b.example.org"
+secret = "s2"
+allowClientIPChoice = true
+adaptiveTTL = { min = 60, max = 3600 }
+`},
+		{name: "miscapitalized key", format: FormatTOML, data: `
+[[domains]]
+domain = "a.example.org"
+secret = "s1"
+
+[[domains]]
+domain = "b.example.org"
+secret = "s2"
+allowClientIpChoice = true
+`, wantErr: "line 6: domains[1] ('b.example.org'): unknown field 'allowClientIpChoice' (did you mean 'allowClientIPChoice'?)"},
+		{name: "unknown nested key", format: FormatTOML, data: `
+[[domains]]
+domain = "a.example.org"
+secret = "s1"
+
+[[domains]]
+domain = "b.example.org"
+secret = "s2"
+adaptiveTTL = { min = 60, maximum = 3600 }
+`, wantErr: "line 6: domains[1] ('b.example.org'): unknown field 'adaptiveTTL.maximum'"},
+		{name: "duplicate entry", format: FormatTOML, data: `
+[[domains]]
+domain = "a.example.org"
+secret = "s1"
+
+[[domains]]
+domain = "A.Example.org."
+secret = "s2"
+`, wantErr: "line 6: domains[1] ('A.Example.org.'): domain is already configured at line 2"},
+		{name: "unknown top-level key", format: FormatTOML, data: `
+domain = []
+`, wantErr: "line 2: unexpected field 'domain'"},
+		{name: "syntax error", format: FormatTOML, data: `
+[[domains]]
+domain = "a.example.org"
+secret = s1
+`, wantErr: "(4, 10): no value can start with s"},
+	}
+	e := &Env{Providers: map[string]provider.Provider{DefaultProvider: &recordLister{}}}
+	for _, tt := range tests {
+		domainsConfig, err := e.ParseDomainsConfig([]byte(tt.data), tt.format)
+		if tt.wantErr != "" {
+			if err == nil || err.Error() != tt.wantErr {
+				t.Errorf("%s %s: got error %v, want %q", tt.format, tt.name, err, tt.wantErr)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s %s: %s", tt.format, tt.name, err)
+			continue
+		}
+		if len(domainsConfig.Domains) != 2 {
+			t.Errorf("%s %s: got %d domains, want 2", tt.format, tt.name, len(domainsConfig.Domains))
+			continue
+		}
+		d := domainsConfig.Domains[1]
+		if d.Domain != "b.example.org" || d.Secret != "s2" || !d.AllowClientIPChoice ||
+			d.AdaptiveTTL == nil || *d.AdaptiveTTL != (AdaptiveTTL{Min: 60, Max: 3600}) {
+			t.Errorf("%s %s: got domains[1] = %+v", tt.format, tt.name, d)
+		}
+	}
+}
+
+func TestDomainsConfigWarnings(t *testing.T) {
+	tests := []struct {
+		name        string
+		domain      DomainConfig
+		wantWarning string
+	}{
+		{name: "no ttl", domain: DomainConfig{Domain: "a.example.org"}},
+		{name: "long ttl", domain: DomainConfig{Domain: "a.example.org", TTL: 600}},
+		{name: "short ttl", domain: DomainConfig{Domain: "a.example.org", TTL: 300},
+			wantWarning: "domains[0] ('a.example.org'): ttl (5m0s) is shorter than the update cache lifetime (10m0s); records changed outside do-ddns may go uncorrected for longer than the TTL"},
+		{name: "long adaptive ttl", domain: DomainConfig{Domain: "a.example.org", AdaptiveTTL: &AdaptiveTTL{Min: 600, Max: 3600}}},
+		{name: "short adaptive ttl", domain: DomainConfig{Domain: "a.example.org", AdaptiveTTL: &AdaptiveTTL{Min: 60, Max: 3600}},
+			wantWarning: "domains[0] ('a.example.org'): adaptiveTTL.min (1m0s) is shorter than the update cache lifetime (10m0s); records changed outside do-ddns may go uncorrected for longer than the TTL"},
+	}
+	for _, tt := range tests {
+		c := &DomainsConfig{Domains: []DomainConfig{tt.domain}}
+		warnings := c.warnings(10 * time.Minute)
+		switch {
+		case tt.wantWarning == "" && len(warnings) != 0:
+			t.Errorf("%s: got warnings %v, want none", tt.name, warnings)
+		case tt.wantWarning != "" && (len(warnings) != 1 || warnings[0].Error() != tt.wantWarning):
+			t.Errorf("%s: got warnings %v, want %q", tt.name, warnings, tt.wantWarning)
+		}
+	}
+}
@@ -0,0 +1,147 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+const zoneListLifetime = 10 * time.Minute
+
+// NoZoneFoundErr indicates that no zone hosted at the domain's DNS provider contains the domain.
+var NoZoneFoundErr = errors.New("no zone found for this domain")
+
+// zoneListEntry is the cached list of zones hosted at one DNS provider account.
+type zoneListEntry struct {
+	// sem is held while reading or listing the zones, so concurrent lookups for one provider account wait for
+	// a single listing, without holding up lookups for other accounts.
+	sem    chan struct{}
+	zones  []string
+	listed time.Time // when the zones were listed (or rather, when listing them began)
+}
+
+// zoneListCache caches the list of zones hosted at each DNS provider, so resolving a domain's zone
+// doesn't require listing the provider's zones on every update.
+type zoneListCache struct {
+	mutex sync.Mutex // guards lists, but not the entries in it
+	lists map[string]*zoneListEntry
+}
+
+// entry returns the cache entry for the given provider key, creating it if necessary.
+func (c *zoneListCache) entry(key string) *zoneListEntry {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.lists == nil {
+		c.lists = make(map[string]*zoneListEntry)
+	}
+	entry, ok := c.lists[key]
+	if !ok {
+		entry = &zoneListEntry{sem: make(chan struct{}, 1)}
+		c.lists[key] = entry
+	}
+	return entry
+}
+
+// Zone returns the name of the zone which contains the given domain, along with the name of the domain's records
+// relative to that zone ("@" if the domain is the zone apex).
+//
+// If the domain's configuration specifies a zone explicitly, that zone is used. Otherwise, the zone is the
+// longest-matching zone hosted at the domain's DNS provider, which may be listed using the given context.
+func (e *Env) Zone(ctx context.Context, c DomainConfig) (zone string, recordName string, err error) {
+	domain := normalizeDomain(c.Domain)
+
+	if c.Zone != "" {
+		zone = normalizeDomain(c.Zone)
+		recordName, ok := relativeName(domain, zone)
+		if !ok {
+			return "", "", fmt.Errorf("domain '%s' is not within its configured zone '%s'", c.Domain, c.Zone)
+		}
+		return zone, recordName, nil
+	}
+
+	zones, err := e.providerZones(ctx, c, false)
+	if err != nil {
+		return "", "", err
+	}
+	zone, recordName, ok := longestMatchingZone(domain, zones)
+	if !ok {
+		// the zone may have been added at the provider since we last listed its zones:
+		if zones, err = e.providerZones(ctx, c, true); err != nil {
+			return "", "", err
+		}
+		if zone, recordName, ok = longestMatchingZone(domain, zones); !ok {
+			return "", "", fmt.Errorf("%w: '%s' (provider '%s')", NoZoneFoundErr, c.Domain, c.ProviderKey())
+		}
+	}
+	return zone, recordName, nil
+}
+
+// providerZones returns the list of zones hosted at the given domain's DNS provider account, from cache
+// if possible unless refresh is true. If refresh is true, a list which another lookup of the same account began
+// fetching after this call is used, rather than listing the zones again.
+func (e *Env) providerZones(ctx context.Context, c DomainConfig, refresh bool) ([]string, error) {
+	key := c.ProviderKey()
+	entry := e.zoneLists.entry(key)
+	requested := time.Now()
+	select {
+	case entry.sem <- struct{}{}:
+		defer func() { <-entry.sem }()
+	case <-ctx.Done():
+		return nil, fmt.Errorf("failed to list zones for provider '%s': %w", key, ctx.Err())
+	}
+
+	fresh := time.Since(entry.listed) < zoneListLifetime
+	if refresh {
+		fresh = entry.listed.After(requested)
+	}
+	if fresh {
+		return entry.zones, nil
+	}
+
+	p, err := e.Provider(c)
+	if err != nil {
+		return nil, err
+	}
+	started := time.Now()
+	zones, err := p.ListZones(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list zones for provider '%s': %w", key, err)
+	}
+
+	entry.zones, entry.listed = zones, started
+	return zones, nil
+}
+
+// longestMatchingZone finds the most specific of the given zones which contains the given domain.
+func longestMatchingZone(domain string, zones []string) (zone string, recordName string, ok bool) {
+	for _, z := range zones {
+		z = normalizeDomain(z)
+		if len(z) <= len(zone) {
+			continue
+		}
+		if name, match := relativeName(domain, z); match {
+			zone, recordName, ok = z, name, true
+		}
+	}
+	return zone, recordName, ok
+}
+
+// relativeName returns the name of the given domain relative to the given zone, or "@" if
+// the domain is the zone apex. It returns false if the domain is not within the zone.
+func relativeName(domain string, zone string) (string, bool) {
+	if domain == zone {
+		return "@", true
+	}
+	if zone != "" && strings.HasSuffix(domain, "."+zone) {
+		return strings.TrimSuffix(domain, "."+zone), true
+	}
+	return "", false
+}
+
+// normalizeDomain lowercases the given domain name and strips any trailing dot.
+func normalizeDomain(domain string) string {
+	return strings.ToLower(strings.TrimSuffix(domain, "."))
+}
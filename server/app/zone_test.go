@@ -0,0 +1,115 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"do-ddns/server/provider"
+)
+
+// zoneLister is a provider.Provider which only lists zones, optionally waiting for release to be closed first.
+type zoneLister struct {
+	provider.Provider
+	zones   []string
+	release chan struct{}
+	calls   int32
+}
+
+func (p *zoneLister) ListZones(ctx context.Context) ([]string, error) {
+	atomic.AddInt32(&p.calls, 1)
+	if p.release != nil {
+		select {
+		case <-p.release:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+	return p.zones, nil
+}
+
+func TestZone(t *testing.T) {
+	p := &zoneLister{zones: []string{"example.org", "lab.example.org.", "example.net"}}
+	e := &Env{Providers: map[string]provider.Provider{DefaultProvider: p}}
+
+	tests := []struct {
+		domain, zone, recordName string
+		wantErr                  bool
+	}{
+		{domain: "example.org", zone: "example.org", recordName: "@"},
+		{domain: "host.example.org", zone: "example.org", recordName: "host"},
+		{domain: "Host.Lab.Example.org.", zone: "lab.example.org", recordName: "host"},
+		{domain: "a.b.example.net", zone: "example.net", recordName: "a.b"},
+		{domain: "example.com", wantErr: true},
+	}
+	for _, tt := range tests {
+		zone, recordName, err := e.Zone(context.Background(), DomainConfig{Domain: tt.domain})
+		if (err != nil) != tt.wantErr {
+			t.Errorf("Zone(%q): error = %v, want error %t", tt.domain, err, tt.wantErr)
+			continue
+		}
+		if zone != tt.zone || recordName != tt.recordName {
+			t.Errorf("Zone(%q) = %q, %q, want %q, %q", tt.domain, zone, recordName, tt.zone, tt.recordName)
+		}
+	}
+	// the zones are listed once, then again for each domain outside them
+	if calls := atomic.LoadInt32(&p.calls); calls != 2 {
+		t.Errorf("zones were listed %d times, want 2", calls)
+	}
+}
+
+func TestProviderZonesLocking(t *testing.T) {
+	slow := &zoneLister{zones: []string{"example.org"}, release: make(chan struct{})}
+	fast := &zoneLister{zones: []string{"example.net"}}
+	e := &Env{Providers: map[string]provider.Provider{
+		DefaultProvider:                         slow,
+		ProviderKey(DefaultProvider, "account"): fast,
+	}}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 3; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := e.providerZones(context.Background(), DomainConfig{Domain: "example.org"}, false); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+
+	for atomic.LoadInt32(&slow.calls) == 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	// a slow listing for one account doesn't hold up another account
+	done := make(chan error)
+	go func() {
+		_, err := e.providerZones(context.Background(), DomainConfig{Domain: "example.net", Account: "account"}, false)
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("listing zones for one account waited for another account")
+	}
+
+	// a lookup waiting for the same account gives up when its context is done
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := e.providerZones(ctx, DomainConfig{Domain: "example.org"}, false); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("got error %v, want %v", err, context.DeadlineExceeded)
+	}
+
+	// concurrent lookups for the same account share one listing
+	close(slow.release)
+	wg.Wait()
+	if calls := atomic.LoadInt32(&slow.calls); calls != 1 {
+		t.Errorf("zones were listed %d times, want 1", calls)
+	}
+}
@@ -6,19 +6,22 @@ import (
 	"time"
 )
 
-const cacheLifetime = 10 * time.Minute
+// DefaultLifetime is how long entries are cached, if DNSUpdateCache.Lifetime is zero.
+const DefaultLifetime = 10 * time.Minute
 
 type cacheEntry struct {
 	value   string
 	expires time.Time
 }
 
-// DNSUpdateCache caches the client IP for a given domain/record type pair for 10 minutes.
+// DNSUpdateCache caches the client IP for a given domain/record type pair for 10 minutes (by default).
 // This allows us to avoid re-checking the DigitalOcean API every minute, even when clients
 // call the update API that frequently.
 //
-// The TTL for these records can therefore be set to 5 minutes, or 300 seconds.
+// A record's TTL is set by its domain's ttl option; it should be no shorter than the cache lifetime,
+// since changes made to the record outside do-ddns aren't noticed until its cache entry expires.
 type DNSUpdateCache struct {
+	Lifetime      time.Duration // how long entries are cached; defaults to DefaultLifetime
 	dnsCacheMutex sync.Mutex
 	dnsCache      map[string]cacheEntry
 }
@@ -39,7 +42,7 @@ func (c *DNSUpdateCache) Get(domain string, recordType string) string {
 	return entry.value
 }
 
-// Set caches the given value for the given domain/record type pair, with a 10-minute TTL.
+// Set caches the given value for the given domain/record type pair, for the cache's lifetime.
 func (c *DNSUpdateCache) Set(domain string, recordType string, value string) {
 	c.dnsCacheMutex.Lock()
 	defer c.dnsCacheMutex.Unlock()
@@ -51,10 +54,25 @@ func (c *DNSUpdateCache) Set(domain string, recordType string, value string) {
 	key := cacheKey(domain, recordType)
 	c.dnsCache[key] = cacheEntry{
 		value:   value,
-		expires: time.Now().Add(cacheLifetime),
+		expires: time.Now().Add(c.lifetime()),
 	}
 }
 
+// Clear removes every entry from the cache.
+func (c *DNSUpdateCache) Clear() {
+	c.dnsCacheMutex.Lock()
+	defer c.dnsCacheMutex.Unlock()
+
+	c.dnsCache = nil
+}
+
 func cacheKey(domain string, recordType string) string {
 	return fmt.Sprintf("%s:%s", domain, recordType)
-}
\ No newline at end of file
+}
+
+func (c *DNSUpdateCache) lifetime() time.Duration {
+	if c.Lifetime == 0 {
+		return DefaultLifetime
+	}
+	return c.Lifetime
+}
@@ -0,0 +1,320 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"do-ddns/server/app"
+	"do-ddns/server/cache"
+	"do-ddns/server/configcrypt"
+	"do-ddns/server/envsecret"
+	"do-ddns/server/provider"
+	"do-ddns/server/secret"
+)
+
+// domainsConfigKeyEnv names the environment variable giving the key for encrypted domains configuration files.
+const domainsConfigKeyEnv = "DOMAINS_CONFIG_KEY"
+
+// subcommands maps the name of each do-ddns-server subcommand to its implementation.
+// Each subcommand receives the command-line arguments following its name.
+var subcommands = map[string]func(args []string) error{
+	"hash-secret":         hashSecret,
+	"derive-secret":       deriveSecret,
+	"check-config":        checkConfig,
+	"generate-config-key": generateConfigKey,
+	"encrypt-config":      encryptConfig,
+	"decrypt-config":      decryptConfig,
+}
+
+// runSubcommand runs the named subcommand with the given arguments, returning the process exit code.
+func runSubcommand(name string, args []string) int {
+	cmd, ok := subcommands[name]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "unknown subcommand '%s' (available: %s)\n", name, strings.Join(subcommandNames(), ", "))
+		return 2
+	}
+	if err := cmd(args); err != nil {
+		if err == flag.ErrHelp {
+			return 2
+		}
+		fmt.Fprintf(os.Stderr, "%s: %s\n", name, err.Error())
+		return 1
+	}
+	return 0
+}
+
+func subcommandNames() []string {
+	names := make([]string, 0, len(subcommands))
+	for name := range subcommands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// checkConfig validates the domains configuration file at the given path, printing every problem found.
+// It's suitable for use in CI, before deploying a configuration change.
+func checkConfig(args []string) error {
+	flags := flag.NewFlagSet("check-config", flag.ContinueOnError)
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: do-ddns-server check-config PATH")
+		fmt.Fprintln(flags.Output(), "Validates the given domains configuration file or directory, exiting with a nonzero status if it's invalid.")
+	}
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() != 1 {
+		flags.Usage()
+		return flag.ErrHelp
+	}
+	configPath := flags.Arg(0)
+
+	env, err := validationEnv(false)
+	if err != nil {
+		return err
+	}
+	domainsConfig, err := env.LoadDomainsConfig(configPath)
+	if err != nil {
+		var configErrs app.ConfigErrors
+		if errors.As(err, &configErrs) {
+			for _, configErr := range configErrs {
+				fmt.Fprintf(os.Stderr, "%s: %s\n", configPath, configErr.Error())
+			}
+			return fmt.Errorf("%d problem(s) found", len(configErrs))
+		}
+		return err
+	}
+
+	for _, warning := range env.DomainsConfigWarnings(domainsConfig) {
+		fmt.Fprintf(os.Stderr, "%s: warning: %s\n", configPath, warning.Error())
+	}
+	fmt.Printf("%s: OK (%d domains)\n", configPath, len(domainsConfig.Domains))
+	return nil
+}
+
+// hashSecret reads a secret from stdin and prints a hash of it, suitable for use as a domain's
+// secret in the domains configuration file.
+func hashSecret(args []string) error {
+	flags := flag.NewFlagSet("hash-secret", flag.ContinueOnError)
+	useBcrypt := flags.Bool("bcrypt", false, "Generate a bcrypt hash, rather than an argon2id hash.")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: do-ddns-server hash-secret [-bcrypt]")
+		fmt.Fprintln(flags.Output(), "Reads a secret from stdin and prints its hash, for use in the domains configuration file.")
+		flags.PrintDefaults()
+	}
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	plaintext, err := readSecret("Secret")
+	if err != nil {
+		return err
+	}
+
+	var hash string
+	if *useBcrypt {
+		hash, err = secret.HashBcrypt(plaintext)
+	} else {
+		hash, err = secret.HashArgon2id(plaintext)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to hash secret: %w", err)
+	}
+	fmt.Println(hash)
+	return nil
+}
+
+// deriveSecret reads a key from stdin and prints the secret derived from it for the given hostname,
+// for use with a credential whose derive method is hmac-sha256.
+func deriveSecret(args []string) error {
+	flags := flag.NewFlagSet("derive-secret", flag.ContinueOnError)
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: do-ddns-server derive-secret HOSTNAME")
+		fmt.Fprintln(flags.Output(), "Reads a credential's key from stdin and prints the secret derived from it for the given hostname.")
+	}
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() != 1 {
+		flags.Usage()
+		return flag.ErrHelp
+	}
+
+	key, err := readSecret("Key")
+	if err != nil {
+		return err
+	}
+	fmt.Println(secret.DeriveHMACSHA256(key, flags.Arg(0)))
+	return nil
+}
+
+// generateConfigKey prints a new key for encrypting domains configuration files.
+func generateConfigKey(args []string) error {
+	flags := flag.NewFlagSet("generate-config-key", flag.ContinueOnError)
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: do-ddns-server generate-config-key")
+		fmt.Fprintf(flags.Output(), "Prints a new key for encrypt-config and decrypt-config. Store it securely, and provide it to the server via %s.\n", domainsConfigKeyEnv)
+	}
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	key, err := configcrypt.GenerateKey()
+	if err != nil {
+		return err
+	}
+	fmt.Println(key.String())
+	return nil
+}
+
+// encryptConfig validates and encrypts a domains configuration file, using the key given via domainsConfigKeyEnv.
+func encryptConfig(args []string) error {
+	flags := flag.NewFlagSet("encrypt-config", flag.ContinueOnError)
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: do-ddns-server encrypt-config INPUT [OUTPUT]")
+		fmt.Fprintf(flags.Output(), "Validates and encrypts the domains configuration file INPUT (or stdin, if INPUT is \"-\") with the key given via %s.\n", domainsConfigKeyEnv)
+		fmt.Fprintf(flags.Output(), "OUTPUT defaults to INPUT with \"%s\" appended; it's required when reading from stdin, and then gives the format.\n", app.EncryptedExt)
+	}
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() < 1 || flags.NArg() > 2 || (flags.Arg(0) == "-" && flags.NArg() != 2) {
+		flags.Usage()
+		return flag.ErrHelp
+	}
+	inPath, outPath := flags.Arg(0), flags.Arg(1)
+	if outPath == "" {
+		outPath = inPath + app.EncryptedExt
+	}
+
+	var plaintext []byte
+	var err error
+	if inPath == "-" {
+		plaintext, err = ioutil.ReadAll(os.Stdin)
+	} else {
+		plaintext, err = ioutil.ReadFile(inPath)
+	}
+	if err != nil {
+		return fmt.Errorf("couldn't read '%s': %w", inPath, err)
+	}
+	if configcrypt.IsEncrypted(plaintext) {
+		return fmt.Errorf("'%s' is already encrypted", inPath)
+	}
+
+	env, err := validationEnv(true)
+	if err != nil {
+		return err
+	}
+	// the server will detect the format from OUTPUT's extension, so it must agree with INPUT's
+	format := app.DomainsConfigFormat(outPath)
+	if inPath != "-" {
+		format = app.DomainsConfigFormat(inPath)
+		if outFormat := app.DomainsConfigFormat(outPath); outFormat != format {
+			return fmt.Errorf("'%s' is %s, but '%s' would be read as %s; give it a \".%s%s\" extension",
+				inPath, strings.ToUpper(format), outPath, strings.ToUpper(outFormat), format, app.EncryptedExt)
+		}
+	}
+	if _, err := env.ParseDomainsConfig(plaintext, format); err != nil {
+		return fmt.Errorf("refusing to encrypt invalid config: %w", err)
+	}
+	encrypted, err := configcrypt.Encrypt(plaintext, env.DomainsConfigKey)
+	if err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(outPath, encrypted, 0644); err != nil {
+		return fmt.Errorf("couldn't write '%s': %w", outPath, err)
+	}
+
+	fmt.Fprintf(os.Stderr, "wrote encrypted config to '%s'\n", outPath)
+	if inPath != "-" {
+		fmt.Fprintf(os.Stderr, "the plaintext config '%s' is no longer needed; consider deleting it\n", inPath)
+	}
+	return nil
+}
+
+// decryptConfig decrypts an encrypted domains configuration file to stdout, using the key given via domainsConfigKeyEnv.
+func decryptConfig(args []string) error {
+	flags := flag.NewFlagSet("decrypt-config", flag.ContinueOnError)
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: do-ddns-server decrypt-config INPUT")
+		fmt.Fprintf(flags.Output(), "Decrypts the encrypted domains configuration file INPUT to stdout, with the key given via %s.\n", domainsConfigKeyEnv)
+	}
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() != 1 {
+		flags.Usage()
+		return flag.ErrHelp
+	}
+	inPath := flags.Arg(0)
+
+	encrypted, err := ioutil.ReadFile(inPath)
+	if err != nil {
+		return fmt.Errorf("couldn't read '%s': %w", inPath, err)
+	}
+	if !configcrypt.IsEncrypted(encrypted) {
+		return fmt.Errorf("'%s' isn't encrypted", inPath)
+	}
+	env, err := validationEnv(true)
+	if err != nil {
+		return err
+	}
+	plaintext, err := configcrypt.Decrypt(encrypted, env.DomainsConfigKey)
+	if err != nil {
+		return fmt.Errorf("couldn't decrypt '%s': %w", inPath, err)
+	}
+	_, err = os.Stdout.Write(plaintext)
+	return err
+}
+
+// validationEnv returns an environment suitable for validating domains configuration files offline, using the
+// accounts and decryption key given in the server configuration. It's an error if requireKey is set and the
+// decryption key is missing.
+func validationEnv(requireKey bool) (*app.Env, error) {
+	cfg, err := readServerConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	// providers aren't contacted while validating the config; only their names (and account names) are needed
+	env := &app.Env{
+		Providers: map[string]provider.Provider{
+			app.DefaultProvider: nil,
+		},
+		UpdateCache: &cache.DNSUpdateCache{Lifetime: time.Duration(cfg.CacheLifetime)},
+	}
+	for _, account := range cfg.DOAccounts {
+		env.Providers[app.ProviderKey(app.DefaultProvider, account)] = nil
+	}
+
+	if cfg.DomainsConfigKey == "" {
+		if requireKey {
+			return nil, fmt.Errorf("environment variable '%s' (or '%s%s') is missing", domainsConfigKeyEnv, domainsConfigKeyEnv, envsecret.FileSuffix)
+		}
+		return env, nil
+	}
+	if env.DomainsConfigKey, err = configcrypt.ParseKey(cfg.DomainsConfigKey); err != nil {
+		return nil, fmt.Errorf("invalid %s: %w", domainsConfigKeyEnv, err)
+	}
+	return env, nil
+}
+
+// readSecret prompts for and reads a single line from stdin, which must not be empty.
+func readSecret(prompt string) (string, error) {
+	fmt.Fprintf(os.Stderr, "%s: ", prompt)
+	line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil && line == "" {
+		return "", fmt.Errorf("failed to read %s: %w", strings.ToLower(prompt), err)
+	}
+	line = strings.TrimRight(line, "\r\n")
+	if line == "" {
+		return "", fmt.Errorf("%s must not be empty", strings.ToLower(prompt))
+	}
+	return line, nil
+}
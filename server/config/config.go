@@ -0,0 +1,301 @@
+// Package config describes the server's configuration, which is assembled from defaults, an optional
+// configuration file (in JSON, YAML, or TOML), environment variables, and command-line flags, in
+// increasing order of precedence.
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"do-ddns/server/configcrypt"
+	"do-ddns/server/envsecret"
+
+	"github.com/pelletier/go-toml"
+	"gopkg.in/yaml.v3"
+)
+
+// Supported log formats.
+const (
+	LogFormatText = "text"
+	LogFormatJSON = "json"
+)
+
+// Supported forwarded headers.
+const (
+	ForwardedHeaderXFF     = "x-forwarded-for"
+	ForwardedHeaderRFC7239 = "forwarded"
+)
+
+// Config is the server's configuration.
+type Config struct {
+	Listen             []string  `json:"listen"`             // addresses to listen on, eg. ":7001", "0.0.0.0:7001", or "ipv6=127.0.0.1:7002"
+	TLS                TLSConfig `json:"tls"`                // if configured, listeners serve HTTPS rather than HTTP
+	UnixSocketMode     FileMode  `json:"unixSocketMode"`     // permissions of Unix sockets created for listen addresses like "unix:/run/do-ddns/http.sock"
+	TrustedProxies     []string  `json:"trustedProxies"`     // CIDR blocks or IPs of proxies whose forwarded-for headers are honored
+	ForwardedHeader    string    `json:"forwardedHeader"`    // the header trusted proxies identify the client with: "x-forwarded-for" or "forwarded"
+	ProxyProtocol      bool      `json:"proxyProtocol"`      // whether to require PROXY protocol headers from trusted proxies
+	DomainsConfigPath  string    `json:"domainsConfigPath"`  // the domains configuration file or directory
+	DomainsConfigWatch bool      `json:"domainsConfigWatch"` // whether to reload the domains configuration automatically when it changes
+	DomainsConfigKey   string    `json:"-"`                  // the base64-encoded key for encrypted domains configuration files; only read from the environment
+	DOAPIKey           string    `json:"doAPIKey"`           // the DigitalOcean API key for the default account
+	DOAccounts         []string  `json:"doAccounts"`         // names of additional DigitalOcean accounts, whose API keys are given by AccountAPIKeyEnv
+	CacheLifetime      Duration  `json:"cacheLifetime"`      // how long a successful update is remembered, avoiding DNS provider API calls
+	APITimeout         Duration  `json:"apiTimeout"`         // the timeout for each DNS provider API request
+	APIRetryBudget     Duration  `json:"apiRetryBudget"`     // the longest to spend retrying a failed DNS provider API request; zero disables retries
+	APIRateLimit       int       `json:"apiRateLimit"`       // DNS provider API requests per hour, per account, which updates are paced to; zero disables pacing
+	APIBurst           int       `json:"apiBurst"`           // DNS provider API requests which may be sent at once, per account, before pacing applies
+	APIQueueTimeout    Duration  `json:"apiQueueTimeout"`    // the longest an update waits to be paced, before failing with "server busy"
+	ShutdownTimeout    Duration  `json:"shutdownTimeout"`    // how long to wait for in-flight requests at shutdown, before cancelling DNS provider API requests
+	LogFormat          string    `json:"logFormat"`          // LogFormatText or LogFormatJSON
+	DebugVars          bool      `json:"debugVars"`          // whether to serve metrics (eg. DNS provider API retries) as JSON at /debug/vars
+}
+
+// TLSConfig configures HTTPS, using either a static certificate or certificates obtained automatically via ACME.
+type TLSConfig struct {
+	CertFile string     `json:"certFile"` // PEM-encoded certificate chain
+	KeyFile  string     `json:"keyFile"`  // PEM-encoded private key
+	ACME     ACMEConfig `json:"acme"`
+}
+
+// ACMEConfig configures automatic certificate management via ACME (eg. Let's Encrypt). Certificates are
+// obtained via the TLS-ALPN-01 challenge on the HTTPS listeners, or via HTTP-01 if HTTPListen is set.
+type ACMEConfig struct {
+	Hosts        []string `json:"hosts"`        // hostnames to obtain certificates for; setting any enables ACME
+	Email        string   `json:"email"`        // contact address for the ACME account; optional
+	AcceptTOS    bool     `json:"acceptTOS"`    // whether the ACME CA's terms of service are accepted; required
+	CacheDir     string   `json:"cacheDir"`     // directory where the account key and certificates are stored
+	DirectoryURL string   `json:"directoryURL"` // the ACME CA's directory URL; defaults to Let's Encrypt's production CA
+	CARootsFile  string   `json:"caRootsFile"`  // PEM-encoded roots trusted when contacting the ACME CA, eg. for a Pebble test server
+	HTTPListen   string   `json:"httpListen"`   // address on which to answer HTTP-01 challenges (eg. ":80"), redirecting other requests to HTTPS
+}
+
+// Enabled returns whether ACME is configured.
+func (c ACMEConfig) Enabled() bool {
+	return len(c.Hosts) > 0
+}
+
+// Enabled returns whether HTTPS is configured.
+func (c TLSConfig) Enabled() bool {
+	return c.CertFile != "" || c.KeyFile != "" || c.ACME.Enabled()
+}
+
+// Default returns the default configuration. It trusts a reverse proxy, like nginx, running on the same host.
+func Default() Config {
+	return Config{
+		Listen:             []string{":7001"},
+		UnixSocketMode:     FileMode(0660),
+		TrustedProxies:     []string{"127.0.0.0/8", "::1/128"},
+		ForwardedHeader:    ForwardedHeaderXFF,
+		DomainsConfigWatch: true,
+		CacheLifetime:      Duration(10 * time.Minute),
+		APITimeout:         Duration(5 * time.Second),
+		APIRetryBudget:     Duration(15 * time.Second),
+		APIRateLimit:       5000,
+		APIBurst:           150,
+		APIQueueTimeout:    Duration(5 * time.Second),
+		ShutdownTimeout:    Duration(30 * time.Second),
+		LogFormat:          LogFormatText,
+	}
+}
+
+// LoadFile reads the configuration file at the given path, overriding the settings it contains.
+// Its format is chosen by extension: ".yaml" or ".yml" for YAML, ".toml" for TOML, and JSON for anything else.
+// Unknown settings are rejected, as is a file containing doAPIKey which every user on the system can read.
+func (c *Config) LoadFile(path string) error {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("couldn't read config file '%s': %w", path, err)
+	}
+
+	// YAML and TOML are converted to JSON, so every format is decoded by the same rules
+	var doc interface{}
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, &doc)
+	case ".toml":
+		var tree *toml.Tree
+		if tree, err = toml.LoadBytes(data); err == nil {
+			doc = tree.ToMap()
+		}
+	default:
+		err = json.Unmarshal(data, &doc)
+	}
+	if err == nil {
+		data, err = json.Marshal(doc)
+	}
+	if err != nil {
+		return fmt.Errorf("couldn't parse config file '%s': %w", path, err)
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(c); err != nil {
+		return fmt.Errorf("couldn't parse config file '%s': %w", path, err)
+	}
+
+	var secrets struct {
+		DOAPIKey string `json:"doAPIKey"`
+	}
+	if err := json.Unmarshal(data, &secrets); err == nil && secrets.DOAPIKey != "" {
+		if err := envsecret.CheckPermissions(path); err != nil {
+			return fmt.Errorf("config file contains doAPIKey, so it must be kept private: %w", err)
+		}
+	}
+	return nil
+}
+
+// Validate returns an error if the configuration is incomplete or inconsistent.
+func (c *Config) Validate() error {
+	if len(c.Listen) == 0 {
+		return errors.New("at least one listen address is required")
+	}
+	if c.UnixSocketMode&^0777 != 0 || c.UnixSocketMode&0600 != 0600 {
+		return fmt.Errorf("invalid Unix socket mode %s (it must be readable and writable by the server's user)", c.UnixSocketMode)
+	}
+	if err := c.TLS.validate(); err != nil {
+		return err
+	}
+	if c.ForwardedHeader != ForwardedHeaderXFF && c.ForwardedHeader != ForwardedHeaderRFC7239 {
+		return fmt.Errorf("unknown forwarded header '%s' (expected '%s' or '%s')", c.ForwardedHeader, ForwardedHeaderXFF, ForwardedHeaderRFC7239)
+	}
+	if c.DomainsConfigPath == "" {
+		return errors.New("domains config path is missing (set DOMAINS_CONFIG_PATH or domainsConfigPath)")
+	}
+	if c.DomainsConfigKey != "" {
+		if _, err := configcrypt.ParseKey(c.DomainsConfigKey); err != nil {
+			return fmt.Errorf("invalid domains config key: %w", err)
+		}
+	}
+	if c.DOAPIKey == "" {
+		return errors.New("DigitalOcean API key is missing (set DO_API_KEY, DO_API_KEY_FILE, or doAPIKey)")
+	}
+	seenAccounts := make(map[string]bool)
+	for _, account := range c.DOAccounts {
+		if !validAccountName.MatchString(account) {
+			return fmt.Errorf("invalid account name '%s' (use only lowercase letters, digits, '-', and '_')", account)
+		}
+		// names ending in "file" would collide with the _FILE variable of the default key (for "file") or of
+		// another account (eg. "team-file" with "team")
+		if strings.HasSuffix(AccountAPIKeyEnv(account), envsecret.FileSuffix) {
+			return fmt.Errorf("invalid account name '%s' (names may not be 'file' or end in '-file' or '_file')", account)
+		}
+		// names differing only by '-' and '_' would share an API key variable
+		if seenAccounts[AccountAPIKeyEnv(account)] {
+			return fmt.Errorf("account '%s' duplicates another account's name", account)
+		}
+		seenAccounts[AccountAPIKeyEnv(account)] = true
+	}
+	if c.CacheLifetime <= 0 {
+		return fmt.Errorf("cache lifetime must be positive (got %s)", c.CacheLifetime)
+	}
+	if c.APITimeout <= 0 {
+		return fmt.Errorf("API timeout must be positive (got %s)", c.APITimeout)
+	}
+	if c.APIRetryBudget < 0 {
+		return fmt.Errorf("API retry budget must not be negative (got %s)", c.APIRetryBudget)
+	}
+	if c.APIRateLimit < 0 {
+		return fmt.Errorf("API rate limit must not be negative (got %d)", c.APIRateLimit)
+	}
+	if c.APIRateLimit > 0 && c.APIBurst < 1 {
+		return fmt.Errorf("API burst must be at least 1 (got %d)", c.APIBurst)
+	}
+	if c.APIQueueTimeout < 0 {
+		return fmt.Errorf("API queue timeout must not be negative (got %s)", c.APIQueueTimeout)
+	}
+	if c.ShutdownTimeout <= 0 {
+		return fmt.Errorf("shutdown timeout must be positive (got %s)", c.ShutdownTimeout)
+	}
+	if c.LogFormat != LogFormatText && c.LogFormat != LogFormatJSON {
+		return fmt.Errorf("unknown log format '%s' (expected '%s' or '%s')", c.LogFormat, LogFormatText, LogFormatJSON)
+	}
+	return nil
+}
+
+func (c TLSConfig) validate() error {
+	if !c.ACME.Enabled() {
+		if (c.CertFile == "") != (c.KeyFile == "") {
+			return errors.New("TLS requires both a certificate file and a key file")
+		}
+		if c.ACME.Email != "" || c.ACME.CacheDir != "" || c.ACME.DirectoryURL != "" || c.ACME.CARootsFile != "" || c.ACME.HTTPListen != "" {
+			return errors.New("ACME settings require at least one ACME host")
+		}
+		return nil
+	}
+	if c.CertFile != "" || c.KeyFile != "" {
+		return errors.New("TLS can use either a static certificate or ACME, but not both")
+	}
+	if !c.ACME.AcceptTOS {
+		return errors.New("ACME requires accepting the CA's terms of service (set acceptTOS)")
+	}
+	if c.ACME.CacheDir == "" {
+		return errors.New("ACME requires a cache directory, so certificates aren't requested again on every restart")
+	}
+	return nil
+}
+
+var validAccountName = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]*$`)
+
+// AccountAPIKeyEnv returns the name of the environment variable giving the API key for the named DigitalOcean
+// account, eg. "DO_API_KEY_TEAM" for the account "team". Like DO_API_KEY, it may also be given in a file or
+// systemd credential; see envsecret.Lookup.
+func AccountAPIKeyEnv(account string) string {
+	return "DO_API_KEY_" + strings.ToUpper(strings.Replace(account, "-", "_", -1))
+}
+
+// Duration is a time.Duration which is written in configuration files as a string, like "90s" or "10m".
+type Duration time.Duration
+
+// String formats the duration like time.Duration.
+func (d Duration) String() string {
+	return time.Duration(d).String()
+}
+
+// MarshalJSON conforms Duration to json.Marshaler.
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(d.String())
+}
+
+// UnmarshalJSON conforms Duration to json.Unmarshaler.
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return fmt.Errorf("duration must be a string like \"90s\" or \"10m\"")
+	}
+	parsed, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(parsed)
+	return nil
+}
+
+// FileMode is an os.FileMode which is written in configuration files as an octal string, like "0660".
+type FileMode os.FileMode
+
+// String formats the mode in octal.
+func (m FileMode) String() string {
+	return fmt.Sprintf("%04o", uint32(m))
+}
+
+// MarshalJSON conforms FileMode to json.Marshaler.
+func (m FileMode) MarshalJSON() ([]byte, error) {
+	return json.Marshal(m.String())
+}
+
+// UnmarshalJSON conforms FileMode to json.Unmarshaler.
+func (m *FileMode) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return fmt.Errorf("file mode must be an octal string like \"0660\"")
+	}
+	return setFileMode(m, s)
+}
@@ -0,0 +1,70 @@
+package config
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestValidateAccountNames(t *testing.T) {
+	tests := []struct {
+		accounts []string
+		wantErr  bool
+	}{
+		{accounts: nil},
+		{accounts: []string{"team", "personal-2", "lab_net"}},
+		{accounts: []string{"profile", "filer", "file2"}},
+		{accounts: []string{"Team"}, wantErr: true},
+		{accounts: []string{"-team"}, wantErr: true},
+		{accounts: []string{"team", "team"}, wantErr: true},
+		{accounts: []string{"team-a", "team_a"}, wantErr: true},
+		{accounts: []string{"file"}, wantErr: true},      // DO_API_KEY_FILE is the default key's file variable
+		{accounts: []string{"team-file"}, wantErr: true}, // DO_API_KEY_TEAM_FILE is account "team"'s file variable
+		{accounts: []string{"team_file"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		c := Default()
+		c.DomainsConfigPath = "/etc/do-ddns/domains.json"
+		c.DOAPIKey = "s3cr3t"
+		c.DOAccounts = tt.accounts
+		if err := c.Validate(); (err != nil) != tt.wantErr {
+			t.Errorf("accounts %q: Validate() = %v, want error %t", tt.accounts, err, tt.wantErr)
+		}
+	}
+}
+
+func TestLoadFileAPIKeyPermissions(t *testing.T) {
+	dir, err := ioutil.TempDir("", "do-ddns-config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	tests := []struct {
+		name    string
+		content string
+		mode    os.FileMode
+		wantErr bool
+	}{
+		{name: "public.yaml", content: "logFormat: json\n", mode: 0644},
+		{name: "private-key.yaml", content: "doAPIKey: s3cr3t\n", mode: 0640},
+		{name: "public-key.yaml", content: "doAPIKey: s3cr3t\n", mode: 0644, wantErr: true},
+		{name: "public-key.toml", content: "doAPIKey = \"s3cr3t\"\n", mode: 0604, wantErr: true},
+		{name: "public-key.json", content: `{"doAPIKey": "s3cr3t"}`, mode: 0644, wantErr: true},
+	}
+	for _, tt := range tests {
+		path := filepath.Join(dir, tt.name)
+		if err := ioutil.WriteFile(path, []byte(tt.content), tt.mode); err != nil {
+			t.Fatal(err)
+		}
+		// WriteFile's mode is subject to the umask
+		if err := os.Chmod(path, tt.mode); err != nil {
+			t.Fatal(err)
+		}
+		c := Default()
+		if err := c.LoadFile(path); (err != nil) != tt.wantErr {
+			t.Errorf("%s: LoadFile() = %v, want error %t", tt.name, err, tt.wantErr)
+		}
+	}
+}
@@ -0,0 +1,408 @@
+package config
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"do-ddns/server/envsecret"
+)
+
+// setting describes a configuration setting which may be overridden by an environment variable and/or
+// a command-line flag.
+type setting struct {
+	env        string // the environment variable, if any
+	flag       string // the command-line flag, if any
+	usage      string
+	isBool     bool // whether the flag may be given without a value
+	allowEmpty bool // whether setting the environment variable to the empty string is meaningful
+	secret     bool // whether the value is a secret, which may also be read from a file (see package envsecret)
+	set        func(c *Config, value string) error
+}
+
+// settings lists every setting which can be overridden. They're applied in order, so a later setting
+// (eg. LISTEN) takes precedence over an earlier one affecting the same value (eg. PORT).
+var settings = []setting{
+	{
+		env:   "PORT",
+		usage: "Port to listen on, on all addresses",
+		set: func(c *Config, v string) error {
+			c.Listen = []string{":" + v}
+			return nil
+		},
+	},
+	{
+		env:   "LISTEN",
+		flag:  "listen",
+		usage: "Comma-separated `addresses` to listen on, eg. \":7001\" or \"0.0.0.0:7001,[::]:7002\"; prefix an address with \"ipv4=\" or \"ipv6=\" to accept only that family's clients",
+		set: func(c *Config, v string) error {
+			c.Listen = splitList(v)
+			return nil
+		},
+	},
+	{
+		env:   "UNIX_SOCKET_MODE",
+		flag:  "unix-socket-mode",
+		usage: "Permissions, in `octal`, of Unix sockets created for listen addresses like \"unix:/run/do-ddns/http.sock\"",
+		set: func(c *Config, v string) error {
+			return setFileMode(&c.UnixSocketMode, v)
+		},
+	},
+	{
+		env:   "TLS_CERT_FILE",
+		flag:  "tls-cert-file",
+		usage: "Serve HTTPS using the PEM-encoded certificate chain in this `file`",
+		set: func(c *Config, v string) error {
+			c.TLS.CertFile = v
+			return nil
+		},
+	},
+	{
+		env:   "TLS_KEY_FILE",
+		flag:  "tls-key-file",
+		usage: "Serve HTTPS using the PEM-encoded private key in this `file`",
+		set: func(c *Config, v string) error {
+			c.TLS.KeyFile = v
+			return nil
+		},
+	},
+	{
+		env:   "TLS_ACME_HOSTS",
+		flag:  "tls-acme-hosts",
+		usage: "Serve HTTPS using certificates obtained via ACME for these comma-separated `hostnames`",
+		set: func(c *Config, v string) error {
+			c.TLS.ACME.Hosts = splitList(v)
+			return nil
+		},
+	},
+	{
+		env:   "TLS_ACME_EMAIL",
+		flag:  "tls-acme-email",
+		usage: "Contact `address` for the ACME account",
+		set: func(c *Config, v string) error {
+			c.TLS.ACME.Email = v
+			return nil
+		},
+	},
+	{
+		env:    "TLS_ACME_ACCEPT_TOS",
+		flag:   "tls-acme-accept-tos",
+		usage:  "Accept the ACME CA's terms of service",
+		isBool: true,
+		set: func(c *Config, v string) (err error) {
+			c.TLS.ACME.AcceptTOS, err = strconv.ParseBool(v)
+			return err
+		},
+	},
+	{
+		env:   "TLS_ACME_CACHE_DIR",
+		flag:  "tls-acme-cache-dir",
+		usage: "Store the ACME account key and certificates in this `directory`",
+		set: func(c *Config, v string) error {
+			c.TLS.ACME.CacheDir = v
+			return nil
+		},
+	},
+	{
+		env:   "TLS_ACME_DIRECTORY_URL",
+		flag:  "tls-acme-directory-url",
+		usage: "The ACME CA's directory `URL` (defaults to Let's Encrypt)",
+		set: func(c *Config, v string) error {
+			c.TLS.ACME.DirectoryURL = v
+			return nil
+		},
+	},
+	{
+		env:   "TLS_ACME_CA_ROOTS_FILE",
+		flag:  "tls-acme-ca-roots-file",
+		usage: "Trust the PEM-encoded roots in this `file` when contacting the ACME CA, eg. for a Pebble test server",
+		set: func(c *Config, v string) error {
+			c.TLS.ACME.CARootsFile = v
+			return nil
+		},
+	},
+	{
+		env:   "TLS_ACME_HTTP_LISTEN",
+		flag:  "tls-acme-http-listen",
+		usage: "Answer ACME HTTP-01 challenges on this `address` (eg. \":80\"), redirecting other requests to HTTPS",
+		set: func(c *Config, v string) error {
+			c.TLS.ACME.HTTPListen = v
+			return nil
+		},
+	},
+	{
+		env:        "TRUSTED_PROXIES",
+		flag:       "trusted-proxies",
+		usage:      "Comma-separated `CIDR blocks` or IPs of proxies whose forwarded-for headers are honored; empty trusts none",
+		allowEmpty: true,
+		set: func(c *Config, v string) error {
+			c.TrustedProxies = splitList(v)
+			return nil
+		},
+	},
+	{
+		env:   "FORWARDED_HEADER",
+		flag:  "forwarded-header",
+		usage: "The `header` trusted proxies identify the client with: \"x-forwarded-for\" or \"forwarded\"; the other is ignored",
+		set: func(c *Config, v string) error {
+			c.ForwardedHeader = strings.ToLower(v)
+			return nil
+		},
+	},
+	{
+		env:    "PROXY_PROTOCOL",
+		flag:   "proxy-protocol",
+		usage:  "Require PROXY protocol headers from trusted proxies",
+		isBool: true,
+		set: func(c *Config, v string) (err error) {
+			c.ProxyProtocol, err = strconv.ParseBool(v)
+			return err
+		},
+	},
+	{
+		env:   "DOMAINS_CONFIG_PATH",
+		flag:  "domains-config",
+		usage: "The domains configuration `file or directory`",
+		set: func(c *Config, v string) error {
+			c.DomainsConfigPath = v
+			return nil
+		},
+	},
+	{
+		env:    "DOMAINS_CONFIG_WATCH",
+		flag:   "domains-config-watch",
+		usage:  "Reload the domains configuration automatically when it changes",
+		isBool: true,
+		set: func(c *Config, v string) (err error) {
+			c.DomainsConfigWatch, err = strconv.ParseBool(v)
+			return err
+		},
+	},
+	{
+		// deliberately not a flag, since command lines are visible to other users
+		env:    "DOMAINS_CONFIG_KEY",
+		secret: true,
+		set: func(c *Config, v string) error {
+			c.DomainsConfigKey = v
+			return nil
+		},
+	},
+	{
+		// deliberately not a flag, since command lines are visible to other users
+		env:    "DO_API_KEY",
+		secret: true,
+		set: func(c *Config, v string) error {
+			c.DOAPIKey = v
+			return nil
+		},
+	},
+	{
+		env:   "DO_ACCOUNTS",
+		flag:  "do-accounts",
+		usage: "Comma-separated `names` of additional DigitalOcean accounts, whose API keys are given by DO_API_KEY_<NAME>",
+		set: func(c *Config, v string) error {
+			c.DOAccounts = splitList(v)
+			return nil
+		},
+	},
+	{
+		env:   "CACHE_LIFETIME",
+		flag:  "cache-lifetime",
+		usage: "How long a successful update is remembered, avoiding DNS provider API calls, as a `duration` (eg. \"10m\")",
+		set: func(c *Config, v string) error {
+			return setDuration(&c.CacheLifetime, v)
+		},
+	},
+	{
+		env:   "API_TIMEOUT",
+		flag:  "api-timeout",
+		usage: "Timeout for each DNS provider API request, as a `duration` (eg. \"5s\")",
+		set: func(c *Config, v string) error {
+			return setDuration(&c.APITimeout, v)
+		},
+	},
+	{
+		env:   "API_RETRY_BUDGET",
+		flag:  "api-retry-budget",
+		usage: "The longest to spend retrying a DNS provider API request which was rate limited or failed, as a `duration` (eg. \"15s\"); \"0s\" disables retries",
+		set: func(c *Config, v string) error {
+			return setDuration(&c.APIRetryBudget, v)
+		},
+	},
+	{
+		env:   "API_RATE_LIMIT",
+		flag:  "api-rate-limit",
+		usage: "DNS provider API `requests` per hour, per account, which updates are paced to; 0 disables pacing",
+		set: func(c *Config, v string) (err error) {
+			c.APIRateLimit, err = strconv.Atoi(v)
+			return err
+		},
+	},
+	{
+		env:   "API_BURST",
+		flag:  "api-burst",
+		usage: "DNS provider API `requests` which may be sent at once, per account, before pacing applies",
+		set: func(c *Config, v string) (err error) {
+			c.APIBurst, err = strconv.Atoi(v)
+			return err
+		},
+	},
+	{
+		env:   "API_QUEUE_TIMEOUT",
+		flag:  "api-queue-timeout",
+		usage: "The longest an update waits to be paced, before failing with \"server busy\", as a `duration` (eg. \"5s\"); \"0s\" fails immediately",
+		set: func(c *Config, v string) error {
+			return setDuration(&c.APIQueueTimeout, v)
+		},
+	},
+	{
+		env:   "SHUTDOWN_TIMEOUT",
+		flag:  "shutdown-timeout",
+		usage: "How long to wait for in-flight requests when shutting down, before cancelling DNS provider API requests, as a `duration` (eg. \"30s\")",
+		set: func(c *Config, v string) error {
+			return setDuration(&c.ShutdownTimeout, v)
+		},
+	},
+	{
+		env:   "LOG_FORMAT",
+		flag:  "log-format",
+		usage: "Log `format`: \"text\" or \"json\"",
+		set: func(c *Config, v string) error {
+			c.LogFormat = v
+			return nil
+		},
+	},
+	{
+		env:    "DEBUG_VARS",
+		flag:   "debug-vars",
+		usage:  "Serve metrics, like DNS provider API request and retry counts, as JSON at /debug/vars",
+		isBool: true,
+		set: func(c *Config, v string) (err error) {
+			c.DebugVars, err = strconv.ParseBool(v)
+			return err
+		},
+	},
+}
+
+// ApplyEnv overrides the configuration with the settings given in environment variables. Empty variables
+// are ignored, except where an empty value is meaningful. Secrets may also be given in files; see envsecret.Lookup.
+func (c *Config) ApplyEnv() error {
+	for _, s := range settings {
+		if s.env == "" {
+			continue
+		}
+		if s.secret {
+			value, ok, err := envsecret.Lookup(s.env)
+			if err != nil {
+				return err
+			}
+			if ok {
+				if err := s.set(c, value); err != nil {
+					return fmt.Errorf("invalid %s: %w", s.env, err)
+				}
+			}
+			continue
+		}
+		value, ok := os.LookupEnv(s.env)
+		if !ok || (value == "" && !s.allowEmpty) {
+			continue
+		}
+		if err := s.set(c, value); err != nil {
+			return fmt.Errorf("invalid %s '%s': %w", s.env, value, err)
+		}
+	}
+	return nil
+}
+
+// Flags records the settings given as command-line flags, so they can be applied to a configuration
+// after it's been loaded from a file and the environment.
+type Flags struct {
+	values []flagValue
+}
+
+// RegisterFlags defines a flag for each setting which can be given on the command line.
+func RegisterFlags(fs *flag.FlagSet) *Flags {
+	flags := &Flags{}
+	for _, s := range settings {
+		if s.flag == "" {
+			continue
+		}
+		usage := s.usage
+		if s.env != "" {
+			usage += fmt.Sprintf(" (overrides %s)", s.env)
+		}
+		usage += "."
+		fs.Var(&flagSetting{setting: s, flags: flags}, s.flag, usage)
+	}
+	return flags
+}
+
+// Apply overrides the configuration with the settings given as command-line flags.
+func (f *Flags) Apply(c *Config) error {
+	for _, v := range f.values {
+		if err := v.setting.set(c, v.value); err != nil {
+			return fmt.Errorf("invalid -%s '%s': %w", v.setting.flag, v.value, err)
+		}
+	}
+	return nil
+}
+
+type flagValue struct {
+	setting setting
+	value   string
+}
+
+// flagSetting conforms a setting to flag.Value, recording the values given for it.
+type flagSetting struct {
+	setting setting
+	flags   *Flags
+}
+
+func (f *flagSetting) String() string {
+	return ""
+}
+
+func (f *flagSetting) Set(value string) error {
+	// validate now, so mistakes are reported alongside other flag errors
+	if err := f.setting.set(&Config{}, value); err != nil {
+		return err
+	}
+	f.flags.values = append(f.flags.values, flagValue{setting: f.setting, value: value})
+	return nil
+}
+
+func (f *flagSetting) IsBoolFlag() bool {
+	return f.setting.isBool
+}
+
+func setDuration(d *Duration, value string) error {
+	parsed, err := time.ParseDuration(value)
+	if err != nil {
+		return err
+	}
+	*d = Duration(parsed)
+	return nil
+}
+
+func setFileMode(m *FileMode, value string) error {
+	parsed, err := strconv.ParseUint(value, 8, 32)
+	if err != nil {
+		return fmt.Errorf("file mode must be octal, like \"0660\"")
+	}
+	*m = FileMode(parsed)
+	return nil
+}
+
+// splitList splits a comma-separated list, trimming whitespace and dropping empty items.
+func splitList(s string) []string {
+	items := []string{}
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
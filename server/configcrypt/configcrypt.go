@@ -0,0 +1,93 @@
+// Package configcrypt encrypts and decrypts configuration files at rest, using NaCl secretbox
+// (XSalsa20-Poly1305) with a 256-bit key. Encrypted files are text, so they can be committed to git.
+package configcrypt
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"golang.org/x/crypto/nacl/secretbox"
+)
+
+// Prefix begins every encrypted file, identifying its format.
+const Prefix = "do-ddns:secretbox:v1:"
+
+// KeySize is the size of an encryption key, in bytes.
+const KeySize = 32
+
+const nonceSize = 24
+
+// DecryptionErr is returned when an encrypted file can't be decrypted with the given key.
+var DecryptionErr = errors.New("decryption failed (wrong key, or the file is corrupt)")
+
+// Key is an encryption key.
+type Key [KeySize]byte
+
+// GenerateKey returns a new random key.
+func GenerateKey() (*Key, error) {
+	key := &Key{}
+	if _, err := io.ReadFull(rand.Reader, key[:]); err != nil {
+		return nil, fmt.Errorf("failed to generate key: %w", err)
+	}
+	return key, nil
+}
+
+// ParseKey parses a base64-encoded key, as produced by Key.String.
+func ParseKey(s string) (*Key, error) {
+	decoded, err := base64.StdEncoding.DecodeString(strings.TrimSpace(s))
+	if err != nil {
+		return nil, fmt.Errorf("malformed key: %w", err)
+	}
+	if len(decoded) != KeySize {
+		return nil, fmt.Errorf("malformed key: expected %d bytes, got %d", KeySize, len(decoded))
+	}
+	key := &Key{}
+	copy(key[:], decoded)
+	return key, nil
+}
+
+// String returns the base64-encoded key.
+func (k *Key) String() string {
+	return base64.StdEncoding.EncodeToString(k[:])
+}
+
+// IsEncrypted returns whether the given file contents were produced by Encrypt.
+func IsEncrypted(data []byte) bool {
+	return bytes.HasPrefix(data, []byte(Prefix))
+}
+
+// Encrypt encrypts the given plaintext with the given key, using a random nonce.
+func Encrypt(plaintext []byte, key *Key) ([]byte, error) {
+	var nonce [nonceSize]byte
+	if _, err := io.ReadFull(rand.Reader, nonce[:]); err != nil {
+		return nil, fmt.Errorf("failed to generate nonce: %w", err)
+	}
+	sealed := secretbox.Seal(nonce[:], plaintext, &nonce, (*[KeySize]byte)(key))
+	return []byte(Prefix + base64.StdEncoding.EncodeToString(sealed) + "\n"), nil
+}
+
+// Decrypt decrypts the given file contents, which must have been produced by Encrypt.
+func Decrypt(data []byte, key *Key) ([]byte, error) {
+	if !IsEncrypted(data) {
+		return nil, errors.New("not an encrypted file")
+	}
+	sealed, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(data[len(Prefix):])))
+	if err != nil {
+		return nil, fmt.Errorf("malformed encrypted file: %w", err)
+	}
+	if len(sealed) < nonceSize+secretbox.Overhead {
+		return nil, errors.New("malformed encrypted file: too short")
+	}
+	var nonce [nonceSize]byte
+	copy(nonce[:], sealed[:nonceSize])
+	plaintext, ok := secretbox.Open(nil, sealed[nonceSize:], &nonce, (*[KeySize]byte)(key))
+	if !ok {
+		return nil, DecryptionErr
+	}
+	return plaintext, nil
+}
@@ -3,20 +3,53 @@ package digitalocean
 // DigitalOcean API code is adapted from https://github.com/anaganisk/digitalocean-dynamic-dns-ip
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"log"
 	"net/http"
 	"strconv"
+	"sync"
 	"time"
 )
 
 const APIBase = "https://api.digitalocean.com/v2"
 
-// APIClient is a client for the DigitalOcean API.
+// DefaultTimeout is the timeout for each API request, if APIClient.Timeout is zero.
+const DefaultTimeout = 5 * time.Second
+
+// DefaultBurst is the number of requests which may be sent at once, if APIClient.Burst is zero.
+const DefaultBurst = 150
+
+// APIClient is a client for the DigitalOcean API. Each APIClient authenticates as a single account,
+// and tracks that account's rate limit separately.
 type APIClient struct {
+	Name        string        // identifies the account in log messages; optional
+	Timeout     time.Duration // the timeout for each API request; must be set before calling SetAPIKey
+	RetryBudget time.Duration // the longest to spend retrying a failed request (eg. on HTTP 429 or 5xx); zero disables retries
+
+	// RequestsPerHour paces requests through a token bucket holding up to Burst requests, so bursts of updates
+	// don't exhaust the account's quota; zero disables pacing. These must be set before calling SetAPIKey.
+	RequestsPerHour int
+	Burst           int
+	// QueueTimeout is the longest a request waits to be sent, before failing with BusyErr; if zero, requests
+	// which can't be sent immediately fail.
+	QueueTimeout time.Duration
+
 	httpClient *http.Client
+	limiter    *limiter
+
+	rateLimitMutex sync.Mutex
+	rateLimit      RateLimit
+}
+
+// RateLimit describes the state of an account's API rate limit, as of the most recent response.
+type RateLimit struct {
+	Limit     int       // requests allowed per hour
+	Remaining int       // requests remaining in the current window
+	Reset     time.Time // when the current window resets
 }
 
 // APIError represents an error from the DigitalOcean API.
@@ -39,14 +72,24 @@ func (e APIError) Error() string {
 // SetAPIKey authenticates this API client with the given API key.
 // It performs a simple check that the API key works, and returns an error if it doesn't.
 func (c *APIClient) SetAPIKey(apiKey string) error {
-	const apiTimeout = 5 * time.Second
-	httpClient := &http.Client{Timeout: apiTimeout}
+	timeout := c.Timeout
+	if timeout == 0 {
+		timeout = DefaultTimeout
+	}
+	httpClient := &http.Client{Timeout: timeout}
 	rt := withHeader(httpClient.Transport)
 	rt.Set("Authorization", "Bearer "+apiKey)
 	rt.Set("Accept", "application/json")
 	rt.Set("Content-Type", "application/json")
 	httpClient.Transport = rt
 	c.httpClient = httpClient
+	if c.RequestsPerHour > 0 {
+		burst := c.Burst
+		if burst == 0 {
+			burst = DefaultBurst
+		}
+		c.limiter = newLimiter(c.RequestsPerHour, burst, time.Now())
+	}
 
 	err := c.GetURL(APIBase+"/account", nil)
 	if err != nil {
@@ -88,12 +131,137 @@ func epochStringToTime(s string) (time.Time, error) {
 	return time.Unix(sec, 0), nil
 }
 
-// Do performs the given request against the DigitalOcean API.
+// RateLimit returns the account's rate limit, as reported by the most recent API response
+// which included rate limit headers. It's the zero RateLimit if no such response has been received.
+func (c *APIClient) RateLimit() RateLimit {
+	c.rateLimitMutex.Lock()
+	defer c.rateLimitMutex.Unlock()
+	return c.rateLimit
+}
+
+// updateRateLimit records the rate limit reported by the given response's headers, if present.
+func (c *APIClient) updateRateLimit(resp *http.Response) {
+	limit, err := strconv.Atoi(resp.Header.Get("Ratelimit-Limit"))
+	if err != nil {
+		return
+	}
+	remaining, err := strconv.Atoi(resp.Header.Get("Ratelimit-Remaining"))
+	if err != nil {
+		return
+	}
+	reset, _ := epochStringToTime(resp.Header.Get("Ratelimit-Reset"))
+
+	rateLimit := RateLimit{Limit: limit, Remaining: remaining, Reset: reset}
+
+	c.rateLimitMutex.Lock()
+	c.rateLimit = rateLimit
+	c.rateLimitMutex.Unlock()
+	if c.limiter != nil {
+		c.limiter.observe(rateLimit, time.Now())
+	}
+}
+
+// waitForLimiter waits until the client's limiter allows a request to be sent. It fails immediately, with an
+// error wrapping BusyErr, if that would take longer than the client's QueueTimeout or outlast the context.
+func (c *APIClient) waitForLimiter(ctx context.Context) error {
+	if c.limiter == nil {
+		return nil
+	}
+	maxWait := c.QueueTimeout
+	if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < maxWait {
+		maxWait = time.Until(deadline)
+	}
+	wait, err := c.limiter.reserve(time.Now(), maxWait)
+	if err != nil {
+		Metrics.Add(MetricBusy, 1)
+		return err
+	}
+	if wait <= 0 {
+		return nil
+	}
+
+	Metrics.Add(MetricQueued, 1)
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		c.limiter.cancel()
+		return ctx.Err()
+	}
+}
+
+// Do performs the given request against the DigitalOcean API, giving up when the request's context is done.
 func (c *APIClient) Do(r *http.Request) (*http.Response, error) {
+	return c.DoContext(r.Context(), r)
+}
+
+// DoContext performs the given request against the DigitalOcean API, giving up when the given context is done.
+// Each attempt is also limited by the client's Timeout.
+//
+// Each attempt first waits for the client's rate limiter; see waitForLimiter. A request which is rate limited, or
+// which fails due to a server or network error, is retried with exponential backoff, or after the delay given by
+// the API, for up to the client's RetryBudget. See retryDelay.
+func (c *APIClient) DoContext(ctx context.Context, r *http.Request) (*http.Response, error) {
+	giveUpAt := time.Now().Add(c.RetryBudget)
+	for attempt := 1; ; attempt++ {
+		req := r.WithContext(ctx)
+		if attempt > 1 && r.GetBody != nil {
+			body, err := r.GetBody()
+			if err != nil {
+				return nil, fmt.Errorf("failed to rewind request body: %w", err)
+			}
+			req.Body = body
+		}
+
+		if err := c.waitForLimiter(ctx); err != nil {
+			return nil, err
+		}
+		resp, err := c.do(req)
+		if err == nil || ctx.Err() != nil {
+			return resp, err
+		}
+		delay, ok := retryDelay(r, resp, err, attempt, time.Now())
+		if !ok {
+			return resp, err
+		}
+		if c.RetryBudget <= 0 || time.Now().Add(delay).After(giveUpAt) {
+			if c.RetryBudget > 0 {
+				Metrics.Add(MetricRetriesGaveUp, 1)
+				log.Printf("%s%s %s failed (%s) after %d attempt(s); not retrying, since the retry budget (%s) would be exceeded\n",
+					c.logPrefix(), r.Method, r.URL.Path, describeFailure(resp, err), attempt, c.RetryBudget)
+			}
+			return resp, err
+		}
+
+		Metrics.Add(MetricRetries, 1)
+		log.Printf("%s%s %s failed (%s); retrying in %s (attempt %d)\n",
+			c.logPrefix(), r.Method, r.URL.Path, describeFailure(resp, err), delay.Round(time.Millisecond), attempt+1)
+		timer := time.NewTimer(delay)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return resp, err
+		}
+	}
+}
+
+// do performs a single attempt at the given request.
+func (c *APIClient) do(r *http.Request) (*http.Response, error) {
+	Metrics.Add(MetricRequests, 1)
 	resp, err := c.httpClient.Do(r)
 	if err != nil {
+		Metrics.Add(MetricTransportErrors, 1)
 		return resp, err
 	}
+	c.updateRateLimit(resp)
+	if resp.StatusCode == http.StatusTooManyRequests {
+		Metrics.Add(MetricRateLimited, 1)
+	} else if resp.StatusCode >= 500 {
+		Metrics.Add(MetricServerErrors, 1)
+	}
 
 	if resp.StatusCode >= 400 {
 		var doErr APIError
@@ -115,7 +283,7 @@ func (c *APIClient) Do(r *http.Request) (*http.Response, error) {
 			} else {
 				resetTimeStr = fmt.Sprintf("(parse error: %s)", err.Error())
 			}
-			log.Printf("Ratelimit-Limit: '%s'; Ratelimit-Remaining: '%s'; Ratelimit-Reset: '%s' (%s)\n", resp.Header.Get("Ratelimit-Limit"), resp.Header.Get("Ratelimit-Remaining"), resp.Header.Get("Ratelimit-Reset"), resetTimeStr)
+			log.Printf("%sRatelimit-Limit: '%s'; Ratelimit-Remaining: '%s'; Ratelimit-Reset: '%s' (%s)\n", c.logPrefix(), resp.Header.Get("Ratelimit-Limit"), resp.Header.Get("Ratelimit-Remaining"), resp.Header.Get("Ratelimit-Reset"), resetTimeStr)
 		}
 		return resp, doErr
 	}
@@ -126,18 +294,23 @@ func (c *APIClient) Do(r *http.Request) (*http.Response, error) {
 // GetURL gets the content of the given URL from the DigitalOcean API, unmarshaling the JSON
 // response into the given respBody (if it's not nil).
 func (c *APIClient) GetURL(url string, respBody interface{}) error {
+	return c.GetURLContext(context.Background(), url, respBody)
+}
+
+// GetURLContext is like GetURL, but gives up when the given context is done.
+func (c *APIClient) GetURLContext(ctx context.Context, url string, respBody interface{}) error {
 	request, err := http.NewRequest("GET", url, nil)
 	if err != nil {
 		return fmt.Errorf("can't build request for '%s': %w", url, err)
 	}
 
-	response, err := c.Do(request)
+	response, err := c.DoContext(ctx, request)
 	if err != nil {
 		return fmt.Errorf("failed to GET '%s': %w", url, err)
 	}
+	defer closeResponse(response)
 
 	if respBody != nil {
-		defer response.Body.Close()
 		body, err := ioutil.ReadAll(response.Body)
 		if err != nil {
 			return fmt.Errorf("failed to read response from '%s': %w", url, err)
@@ -151,3 +324,16 @@ func (c *APIClient) GetURL(url string, respBody interface{}) error {
 	return nil
 }
 
+// closeResponse drains and closes the given response's body, so its connection can be reused.
+func closeResponse(resp *http.Response) {
+	_, _ = io.Copy(ioutil.Discard, resp.Body)
+	resp.Body.Close()
+}
+
+// logPrefix identifies the account in log messages, if the client is named.
+func (c *APIClient) logPrefix() string {
+	if c.Name == "" {
+		return ""
+	}
+	return fmt.Sprintf("account '%s': ", c.Name)
+}
@@ -2,22 +2,28 @@ package digitalocean
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
-	"errors"
 	"fmt"
 	"log"
 	"net/http"
 	"net/url"
+	"strconv"
+
+	"do-ddns/server/provider"
 )
 
 // NoRecordsFoundErr indicates that the client failed to find any records for the given domain.
-var NoRecordsFoundErr = errors.New("no records found for this domain")
+var NoRecordsFoundErr = provider.NoRecordsFoundErr
 
 // NoMatchingRecordsFoundErr indicates that the client failed to find any records for the given domain matching the given record name and type.
-var NoMatchingRecordsFoundErr = errors.New("no records found for this domain with the given name and type")
+var NoMatchingRecordsFoundErr = provider.NoMatchingRecordsFoundErr
 
 // InvalidRecordTypeErr indicates that an invalid record type was specified.
-var InvalidRecordTypeErr = errors.New("invalid record type")
+var InvalidRecordTypeErr = provider.InvalidRecordTypeErr
+
+// APIClient conforms to the provider.Provider interface.
+var _ provider.Provider = &APIClient{}
 
 // DNSRecord represents a DNS record in the DigitalOcean API.
 type DNSRecord struct {
@@ -33,6 +39,25 @@ type DNSRecord struct {
 	Data     string  `json:"data"`
 }
 
+// DomainsResponse represents a DigitalOcean List All Domains response.
+type DomainsResponse struct {
+	Domains []struct {
+		Name string `json:"name"`
+		TTL  int    `json:"ttl"`
+	} `json:"domains"`
+	Meta struct {
+		Total int `json:"total"`
+	} `json:"meta"`
+	Links struct {
+		Pages struct {
+			First    string `json:"first"`
+			Previous string `json:"prev"`
+			Next     string `json:"next"`
+			Last     string `json:"last"`
+		} `json:"pages"`
+	} `json:"links"`
+}
+
 // DNSRecordsResponse represents a DigitalOcean DNS Records response.
 type DNSRecordsResponse struct {
 	DomainRecords []DNSRecord `json:"domain_records"`
@@ -54,6 +79,7 @@ type CreateRecordRequest struct {
 	Type string `json:"type"`
 	Name string `json:"name"`
 	Data string `json:"data"`
+	TTL  int    `json:"ttl,omitempty"` // if omitted, DigitalOcean's default TTL is used
 }
 
 // CreateRecordResponse represents a DigitalOcean Create DNS Record response.
@@ -61,13 +87,40 @@ type CreateRecordResponse struct {
 	DomainRecord DNSRecord `json:"domain_record"`
 }
 
+// ListZones gets the names of all the domains in the DigitalOcean account, conforming APIClient to the
+// provider.Provider interface.
+func (c *APIClient) ListZones(ctx context.Context) ([]string, error) {
+	retv := make([]string, 0)
+	uri := APIBase + "/domains"
+	for uri != "" {
+		page := DomainsResponse{}
+		if err := c.GetURLContext(ctx, uri, &page); err != nil {
+			return nil, err
+		}
+		for _, d := range page.Domains {
+			retv = append(retv, d.Name)
+		}
+		if uri == page.Links.Pages.Last {
+			uri = ""
+		} else {
+			uri = page.Links.Pages.Next
+		}
+	}
+	return retv, nil
+}
+
 // GetDomainRecords gets the DNS records of the given domain.
 func (c *APIClient) GetDomainRecords(domain string) ([]DNSRecord, error) {
+	return c.GetDomainRecordsContext(context.Background(), domain)
+}
+
+// GetDomainRecordsContext is like GetDomainRecords, but gives up when the given context is done.
+func (c *APIClient) GetDomainRecordsContext(ctx context.Context, domain string) ([]DNSRecord, error) {
 	retv := make([]DNSRecord, 0)
 	page := DNSRecordsResponse{}
 	uri := APIBase + "/domains/" + url.PathEscape(domain) + "/records"
 	for uri != "" {
-		if err := c.GetURL(uri, &page); err != nil {
+		if err := c.GetURLContext(ctx, uri, &page); err != nil {
 			return nil, err
 		}
 		retv = append(retv, page.DomainRecords...)
@@ -80,12 +133,35 @@ func (c *APIClient) GetDomainRecords(domain string) ([]DNSRecord, error) {
 	return retv, nil
 }
 
+// GetRecords gets the DNS records of the given domain, conforming APIClient to the provider.Provider interface.
+func (c *APIClient) GetRecords(ctx context.Context, rootDomain string) ([]provider.Record, error) {
+	doRecords, err := c.GetDomainRecordsContext(ctx, rootDomain)
+	if err != nil {
+		return nil, err
+	}
+	retv := make([]provider.Record, len(doRecords))
+	for i, doRecord := range doRecords {
+		retv[i] = provider.Record{
+			ID:   strconv.FormatInt(doRecord.ID, 10),
+			Type: doRecord.Type,
+			Name: doRecord.Name,
+			Data: doRecord.Data,
+			TTL:  doRecord.TTL,
+		}
+	}
+	return retv, nil
+}
+
 // UpdateRecords updates any of the given root domain's records, with the given record name & record type,
-// to the given value.
-func (c *APIClient) UpdateRecords(rootDomain string, recordName string, recordType string, value string) error {
-	log.Printf("updating %s records for '%s.%s' to '%s'\n", recordType, recordName, rootDomain, value)
+// to the given value and TTL. If ttl is 0, the records' TTLs are left unchanged.
+func (c *APIClient) UpdateRecords(ctx context.Context, rootDomain string, recordName string, recordType string, value string, ttl int) error {
+	if ttl != 0 {
+		log.Printf("%supdating %s records for '%s.%s' to '%s' (TTL %ds)\n", c.logPrefix(), recordType, recordName, rootDomain, value, ttl)
+	} else {
+		log.Printf("%supdating %s records for '%s.%s' to '%s'\n", c.logPrefix(), recordType, recordName, rootDomain, value)
+	}
 
-	doRecords, err := c.GetDomainRecords(rootDomain)
+	doRecords, err := c.GetDomainRecordsContext(ctx, rootDomain)
 	if err != nil {
 		return err
 	}
@@ -97,11 +173,14 @@ func (c *APIClient) UpdateRecords(rootDomain string, recordName string, recordTy
 	for _, doRecord := range doRecords {
 		if doRecord.Name == recordName && doRecord.Type == recordType {
 			foundRecords++
-			if doRecord.Data == value {
+			if doRecord.Data == value && (ttl == 0 || doRecord.TTL == ttl) {
 				continue
 			}
 
 			doRecord.Data = value
+			if ttl != 0 {
+				doRecord.TTL = ttl
+			}
 			update, err := json.Marshal(doRecord)
 			if err != nil {
 				return fmt.Errorf("failed to marshal record to JSON: %w", err)
@@ -114,10 +193,11 @@ func (c *APIClient) UpdateRecords(rootDomain string, recordName string, recordTy
 				return fmt.Errorf("failed to build update request: %w", err)
 			}
 
-			_, err = c.Do(req)
+			resp, err := c.DoContext(ctx, req)
 			if err != nil {
 				return fmt.Errorf("update failed: %w", err)
 			}
+			closeResponse(resp)
 		}
 	}
 
@@ -129,7 +209,8 @@ func (c *APIClient) UpdateRecords(rootDomain string, recordName string, recordTy
 }
 
 // CreateRecord creates a DNS record according to the given values. Currently only A and AAAA records are supported.
-func (c *APIClient) CreateRecord(rootDomain string, recordName string, recordType string, value string) error {
+// If ttl is 0, DigitalOcean's default TTL is used.
+func (c *APIClient) CreateRecord(ctx context.Context, rootDomain string, recordName string, recordType string, value string, ttl int) error {
 	if recordType != "A" && recordType != "AAAA" {
 		return InvalidRecordTypeErr
 	}
@@ -138,6 +219,7 @@ func (c *APIClient) CreateRecord(rootDomain string, recordName string, recordTyp
 		Type: recordType,
 		Name: recordName,
 		Data: value,
+		TTL:  ttl,
 	})
 	if err != nil {
 		return fmt.Errorf("failed to marshal request to JSON: %w", err)
@@ -150,10 +232,50 @@ func (c *APIClient) CreateRecord(rootDomain string, recordName string, recordTyp
 		return fmt.Errorf("failed to build create request: %w", err)
 	}
 
-	_, err = c.Do(req)
+	resp, err := c.DoContext(ctx, req)
 	if err != nil {
 		return fmt.Errorf("create failed: %w", err)
 	}
+	closeResponse(resp)
+
+	return nil
+}
+
+// DeleteRecords deletes any of the given root domain's records with the given record name & record type.
+func (c *APIClient) DeleteRecords(ctx context.Context, rootDomain string, recordName string, recordType string) error {
+	log.Printf("%sdeleting %s records for '%s.%s'\n", c.logPrefix(), recordType, recordName, rootDomain)
+
+	doRecords, err := c.GetDomainRecordsContext(ctx, rootDomain)
+	if err != nil {
+		return err
+	}
+	if len(doRecords) < 1 {
+		return NoRecordsFoundErr
+	}
+
+	foundRecords := 0
+	for _, doRecord := range doRecords {
+		if doRecord.Name == recordName && doRecord.Type == recordType {
+			foundRecords++
+
+			req, err := http.NewRequest("DELETE",
+				fmt.Sprintf("%s/domains/%s/records/%d", APIBase, url.PathEscape(rootDomain), doRecord.ID),
+				nil)
+			if err != nil {
+				return fmt.Errorf("failed to build delete request: %w", err)
+			}
+
+			resp, err := c.DoContext(ctx, req)
+			if err != nil {
+				return fmt.Errorf("delete failed: %w", err)
+			}
+			closeResponse(resp)
+		}
+	}
+
+	if foundRecords == 0 {
+		return NoMatchingRecordsFoundErr
+	}
 
 	return nil
 }
@@ -0,0 +1,115 @@
+package digitalocean
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// trackedBody is a response body which records whether it was read to the end and closed.
+type trackedBody struct {
+	io.Reader
+	drained, closed bool
+}
+
+func (b *trackedBody) Read(p []byte) (int, error) {
+	n, err := b.Reader.Read(p)
+	if err == io.EOF {
+		b.drained = true
+	}
+	return n, err
+}
+
+func (b *trackedBody) Close() error {
+	b.closed = true
+	return nil
+}
+
+// fakeAPI is an http.RoundTripper which answers requests with its handler, in place of the DigitalOcean API.
+// A status of zero fails the request with a transport error.
+type fakeAPI struct {
+	handler func(r *http.Request) (status int, header http.Header, body string)
+
+	mutex    sync.Mutex
+	requests []string // "METHOD path" for each request
+	bodies   []*trackedBody
+}
+
+func (f *fakeAPI) RoundTrip(r *http.Request) (*http.Response, error) {
+	status, header, body := f.handler(r)
+	if header == nil {
+		header = make(http.Header)
+	}
+	b := &trackedBody{Reader: strings.NewReader(body)}
+
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.requests = append(f.requests, r.Method+" "+r.URL.Path)
+	if status == 0 {
+		return nil, errors.New("connection reset by peer")
+	}
+	f.bodies = append(f.bodies, b)
+	return &http.Response{StatusCode: status, Header: header, Body: b, Request: r}, nil
+}
+
+// client returns an APIClient which sends its requests to this fake API.
+func (f *fakeAPI) client() *APIClient {
+	return &APIClient{httpClient: &http.Client{Transport: f}}
+}
+
+func TestResponsesAreClosed(t *testing.T) {
+	api := &fakeAPI{handler: func(r *http.Request) (int, http.Header, string) {
+		switch r.Method {
+		case "GET":
+			if strings.HasSuffix(r.URL.Path, "/records") {
+				return http.StatusOK, nil, `{"domain_records": [
+					{"id": 1, "type": "A", "name": "home", "data": "192.0.2.1"},
+					{"id": 2, "type": "A", "name": "home", "data": "192.0.2.2"}
+				]}`
+			}
+			return http.StatusOK, nil, `{"account": {}}`
+		case "DELETE":
+			return http.StatusNoContent, nil, ""
+		default:
+			return http.StatusOK, nil, `{"domain_record": {}}`
+		}
+	}}
+	c := api.client()
+	ctx := context.Background()
+
+	if err := c.GetURL(APIBase+"/account", nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.UpdateRecords(ctx, "example.org", "home", "A", "192.0.2.9", 0); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.CreateRecord(ctx, "example.org", "new", "A", "192.0.2.9", 0); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.DeleteRecords(ctx, "example.org", "home", "A"); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{
+		"GET /v2/account",
+		"GET /v2/domains/example.org/records",
+		"PUT /v2/domains/example.org/records/1",
+		"PUT /v2/domains/example.org/records/2",
+		"POST /v2/domains/example.org/records",
+		"GET /v2/domains/example.org/records",
+		"DELETE /v2/domains/example.org/records/1",
+		"DELETE /v2/domains/example.org/records/2",
+	}
+	if strings.Join(api.requests, "\n") != strings.Join(want, "\n") {
+		t.Errorf("got requests:\n%s\nwant:\n%s", strings.Join(api.requests, "\n"), strings.Join(want, "\n"))
+	}
+	for i, b := range api.bodies {
+		if !b.drained || !b.closed {
+			t.Errorf("response to %s: drained = %t, closed = %t; want both", api.requests[i], b.drained, b.closed)
+		}
+	}
+}
@@ -0,0 +1,89 @@
+package digitalocean
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"do-ddns/server/provider"
+)
+
+// BusyErr indicates that a request wasn't sent, because it would have had to wait too long for the API rate limiter.
+var BusyErr = provider.BusyErr
+
+// limiter is a token bucket pacing an account's API requests, so bursts of updates queue briefly rather than
+// exhausting the account's quota. It also tracks the quota remaining, as reported by the API, so requests wait
+// for the quota to reset rather than being sent when they'd certainly be rejected.
+type limiter struct {
+	mutex        sync.Mutex
+	rate         float64   // tokens added per second
+	burst        float64   // the most tokens the bucket holds
+	tokens       float64   // tokens in the bucket; negative when requests are waiting
+	updated      time.Time // when tokens was last updated
+	blockedUntil time.Time // when the account's exhausted quota resets
+}
+
+func newLimiter(perHour int, burst int, now time.Time) *limiter {
+	return &limiter{
+		rate:    float64(perHour) / time.Hour.Seconds(),
+		burst:   float64(burst),
+		tokens:  float64(burst),
+		updated: now,
+	}
+}
+
+// reserve takes a token, returning how long the caller must wait before sending its request. If that's longer
+// than maxWait, no token is taken, and an error wrapping BusyErr is returned.
+func (l *limiter) reserve(now time.Time, maxWait time.Duration) (time.Duration, error) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	l.advance(now)
+
+	var wait time.Duration
+	if l.tokens < 1 {
+		wait = time.Duration((1 - l.tokens) / l.rate * float64(time.Second))
+	}
+	if blocked := l.blockedUntil.Sub(now); blocked > wait {
+		wait = blocked
+	}
+	if wait > maxWait {
+		return wait, fmt.Errorf("%w (the next request could be sent in %s)", BusyErr, wait.Round(time.Second))
+	}
+	l.tokens--
+	return wait, nil
+}
+
+// cancel returns a token taken by reserve, when the caller gives up before sending its request.
+func (l *limiter) cancel() {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	if l.tokens++; l.tokens > l.burst {
+		l.tokens = l.burst
+	}
+}
+
+// observe adjusts the limiter to the account's quota, as reported by an API response.
+func (l *limiter) observe(rateLimit RateLimit, now time.Time) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	l.advance(now)
+
+	if rateLimit.Remaining <= 0 && rateLimit.Reset.After(now) {
+		l.blockedUntil = rateLimit.Reset
+	}
+	// other servers (or other software using the same API key) may be using the quota too
+	if remaining := float64(rateLimit.Remaining); remaining < l.tokens {
+		l.tokens = remaining
+	}
+}
+
+// advance adds the tokens accrued since the limiter was last updated.
+func (l *limiter) advance(now time.Time) {
+	if elapsed := now.Sub(l.updated); elapsed > 0 {
+		l.tokens += elapsed.Seconds() * l.rate
+		if l.tokens > l.burst {
+			l.tokens = l.burst
+		}
+		l.updated = now
+	}
+}
@@ -0,0 +1,146 @@
+package digitalocean
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"do-ddns/server/provider"
+)
+
+func TestLimiterReserve(t *testing.T) {
+	start := time.Date(2020, 6, 1, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name     string
+		reserved int           // tokens taken at the start
+		after    time.Duration // when the next token is asked for
+		maxWait  time.Duration
+		wantWait time.Duration
+		wantBusy bool
+	}{
+		{name: "within the burst", reserved: 2, wantWait: 0},
+		{name: "burst exhausted", reserved: 3, wantWait: time.Second, wantBusy: true},
+		{name: "burst exhausted, queued", reserved: 3, maxWait: time.Second, wantWait: time.Second},
+		{name: "burst exhausted, partly refilled", reserved: 3, after: 250 * time.Millisecond, maxWait: time.Second, wantWait: 750 * time.Millisecond},
+		{name: "burst refilled", reserved: 3, after: time.Second, wantWait: 0},
+		{name: "queue too long", reserved: 4, maxWait: time.Second, wantWait: 2 * time.Second, wantBusy: true},
+	}
+	for _, tt := range tests {
+		// 3600 requests per hour: one a second
+		l := newLimiter(3600, 3, start)
+		for i := 0; i < tt.reserved; i++ {
+			if _, err := l.reserve(start, time.Hour); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		wait, err := l.reserve(start.Add(tt.after), tt.maxWait)
+		if busy := errors.Is(err, provider.BusyErr); busy != tt.wantBusy || (err != nil && !busy) {
+			t.Errorf("%s: got error %v, want busy: %t", tt.name, err, tt.wantBusy)
+		}
+		if wait != tt.wantWait {
+			t.Errorf("%s: got wait %s, want %s", tt.name, wait, tt.wantWait)
+		}
+	}
+}
+
+func TestLimiterBusyDoesNotTakeToken(t *testing.T) {
+	start := time.Date(2020, 6, 1, 12, 0, 0, 0, time.UTC)
+	l := newLimiter(360, 1, start)
+	if _, err := l.reserve(start, 0); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		if _, err := l.reserve(start, 0); !errors.Is(err, provider.BusyErr) {
+			t.Fatalf("got error %v, want BusyErr", err)
+		}
+	}
+	if wait, err := l.reserve(start.Add(10*time.Second), 0); wait != 0 || err != nil {
+		t.Errorf("after refilling: got wait %s and error %v, want neither", wait, err)
+	}
+}
+
+func TestLimiterCancel(t *testing.T) {
+	start := time.Date(2020, 6, 1, 12, 0, 0, 0, time.UTC)
+	l := newLimiter(360, 1, start)
+
+	if wait, err := l.reserve(start, time.Minute); wait != 0 || err != nil {
+		t.Fatalf("got wait %s and error %v, want neither", wait, err)
+	}
+	if wait, err := l.reserve(start, time.Minute); wait != 10*time.Second || err != nil {
+		t.Fatalf("got wait %s and error %v, want 10s", wait, err)
+	}
+	// the queued request gives up, returning its token, so the next one waits no longer than it would have
+	l.cancel()
+	if wait, err := l.reserve(start, time.Minute); wait != 10*time.Second || err != nil {
+		t.Errorf("after cancel: got wait %s and error %v, want 10s", wait, err)
+	}
+
+	// a full bucket doesn't overflow
+	l = newLimiter(360, 1, start)
+	l.cancel()
+	l.reserve(start, 0)
+	if _, err := l.reserve(start, 0); !errors.Is(err, provider.BusyErr) {
+		t.Errorf("after cancelling with a full bucket: got error %v, want BusyErr", err)
+	}
+}
+
+func TestLimiterObserve(t *testing.T) {
+	start := time.Date(2020, 6, 1, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name      string
+		rateLimit RateLimit
+		after     time.Duration // when the next token is asked for
+		wantWait  time.Duration
+	}{
+		{name: "quota remaining", rateLimit: RateLimit{Limit: 5000, Remaining: 4000, Reset: start.Add(time.Hour)}, wantWait: 0},
+		{name: "quota exhausted", rateLimit: RateLimit{Limit: 5000, Remaining: 0, Reset: start.Add(time.Minute)}, wantWait: time.Minute},
+		{name: "quota exhausted, partly waited", rateLimit: RateLimit{Limit: 5000, Remaining: 0, Reset: start.Add(time.Minute)},
+			after: 45 * time.Second, wantWait: 15 * time.Second},
+		{name: "quota reset", rateLimit: RateLimit{Limit: 5000, Remaining: 0, Reset: start.Add(time.Minute)},
+			after: time.Minute, wantWait: 0},
+		{name: "quota exhausted, reset in the past", rateLimit: RateLimit{Limit: 5000, Remaining: 0, Reset: start.Add(-time.Minute)},
+			wantWait: 10 * time.Second},
+		{name: "quota nearly exhausted", rateLimit: RateLimit{Limit: 5000, Remaining: 1, Reset: start.Add(time.Hour)}, wantWait: 0},
+	}
+	for _, tt := range tests {
+		l := newLimiter(360, 3, start)
+		l.observe(tt.rateLimit, start)
+
+		wait, err := l.reserve(start.Add(tt.after), time.Hour)
+		if err != nil {
+			t.Errorf("%s: %s", tt.name, err)
+		}
+		if wait != tt.wantWait {
+			t.Errorf("%s: got wait %s, want %s", tt.name, wait, tt.wantWait)
+		}
+	}
+}
+
+func TestDoContextBusy(t *testing.T) {
+	api := &fakeAPI{handler: func(r *http.Request) (int, http.Header, string) {
+		return http.StatusOK, http.Header{
+			"Ratelimit-Limit":     {"5000"},
+			"Ratelimit-Remaining": {"4999"},
+		}, `{"account": {}}`
+	}}
+	c := api.client()
+	c.limiter = newLimiter(360, 2, time.Now())
+
+	for i := 0; i < 2; i++ {
+		if err := c.GetURLContext(context.Background(), APIBase+"/account", nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	err := c.GetURLContext(context.Background(), APIBase+"/account", nil)
+	if !errors.Is(err, provider.BusyErr) {
+		t.Errorf("with the burst exhausted: got error %v, want BusyErr", err)
+	}
+	if len(api.requests) != 2 {
+		t.Errorf("got %d requests, want 2", len(api.requests))
+	}
+}
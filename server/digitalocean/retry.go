@@ -0,0 +1,114 @@
+package digitalocean
+
+import (
+	"expvar"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const (
+	// retryBaseDelay is the delay before the first retry of a failed request, before jitter.
+	retryBaseDelay = 500 * time.Millisecond
+
+	// retryMaxDelay limits the exponential backoff between retries, except when the API says how long to wait.
+	retryMaxDelay = 10 * time.Second
+)
+
+// Metrics counts API requests, retries, and rate limiter waits across every APIClient, published via expvar
+// as "digitalocean".
+var Metrics = expvar.NewMap("digitalocean")
+
+// Keys of the counters in Metrics.
+const (
+	MetricRequests        = "requests"        // attempts sent to the API, including retries
+	MetricRateLimited     = "rateLimited"     // attempts which received HTTP 429
+	MetricRetries         = "retries"         // failed attempts which were retried
+	MetricRetriesGaveUp   = "retriesGaveUp"   // failed attempts which weren't retried, because the retry budget ran out
+	MetricServerErrors    = "serverErrors"    // attempts which received HTTP 5xx
+	MetricTransportErrors = "transportErrors" // attempts which failed without a response (eg. a timeout or network error)
+	MetricQueued          = "queued"          // attempts which waited for the rate limiter
+	MetricBusy            = "busy"            // attempts which weren't sent, because the rate limiter's queue was too long
+)
+
+// retryDelay returns how long to wait before retrying a failed attempt at the given request, which received the
+// given response and/or error at the given time, and whether it should be retried at all. Rate-limited requests (HTTP 429) are
+// always retried, since the API didn't act on them; server errors and transport errors are only retried for
+// idempotent requests, since a POST may have taken effect before failing.
+func retryDelay(r *http.Request, resp *http.Response, err error, attempt int, now time.Time) (time.Duration, bool) {
+	if r.Body != nil && r.GetBody == nil {
+		return 0, false // the body can't be sent again
+	}
+	idempotent := r.Method != "POST"
+
+	switch {
+	case resp == nil:
+		if err == nil || !idempotent {
+			return 0, false
+		}
+		return backoff(attempt), true
+	case resp.StatusCode == http.StatusTooManyRequests:
+		if delay, ok := retryAfter(resp, now); ok {
+			return delay, true
+		}
+		if resp.Header.Get("Ratelimit-Remaining") == "0" {
+			if reset, err := epochStringToTime(resp.Header.Get("Ratelimit-Reset")); err == nil {
+				return reset.Sub(now) + jitter(retryBaseDelay), true
+			}
+		}
+		return backoff(attempt), true
+	case resp.StatusCode >= 500 && idempotent:
+		if delay, ok := retryAfter(resp, now); ok {
+			return delay, true
+		}
+		return backoff(attempt), true
+	default:
+		return 0, false
+	}
+}
+
+// retryAfter returns the delay given by the response's Retry-After header, which may be a number of seconds
+// or an HTTP date (relative to the given time).
+func retryAfter(resp *http.Response, now time.Time) (time.Duration, bool) {
+	value := resp.Header.Get("Retry-After")
+	if value == "" {
+		return 0, false
+	}
+	if seconds, err := strconv.Atoi(value); err == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second, true
+	}
+	if t, err := http.ParseTime(value); err == nil {
+		return t.Sub(now), true
+	}
+	return 0, false
+}
+
+// backoff returns the delay before the given attempt's retry: exponential in the number of attempts so far, up to
+// retryMaxDelay, with jitter so that clients which failed together don't retry together.
+func backoff(attempt int) time.Duration {
+	delay := retryMaxDelay
+	if attempt < 16 {
+		if d := retryBaseDelay << uint(attempt-1); d < retryMaxDelay {
+			delay = d
+		}
+	}
+	return delay/2 + jitter(delay/2)
+}
+
+// jitter returns a random duration in [0, max).
+func jitter(max time.Duration) time.Duration {
+	if max <= 0 {
+		return 0
+	}
+	return time.Duration(rand.Int63n(int64(max)))
+}
+
+// describeFailure summarizes a failed attempt for log messages.
+func describeFailure(resp *http.Response, err error) string {
+	if resp != nil {
+		return fmt.Sprintf("HTTP %d", resp.StatusCode)
+	}
+	return err.Error()
+}
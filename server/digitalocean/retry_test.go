@@ -0,0 +1,170 @@
+package digitalocean
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRetryDelay(t *testing.T) {
+	now := time.Date(2020, 6, 1, 12, 0, 0, 0, time.UTC)
+	header := func(kv ...string) http.Header {
+		h := make(http.Header)
+		for i := 0; i < len(kv); i += 2 {
+			h.Set(kv[i], kv[i+1])
+		}
+		return h
+	}
+	transportErr := errors.New("connection reset by peer")
+
+	tests := []struct {
+		name         string
+		method       string
+		unrewindable bool // the request has a body which can't be sent again
+		status       int  // zero for no response
+		header       http.Header
+		err          error
+		attempt      int
+		wantRetry    bool
+		min, max     time.Duration // the range of the delay: [min, max)
+	}{
+		{name: "429 with Retry-After seconds", method: "GET", status: 429, header: header("Retry-After", "30"),
+			wantRetry: true, min: 30 * time.Second, max: 30*time.Second + 1},
+		{name: "429 with Retry-After date", method: "GET", status: 429,
+			header:    header("Retry-After", now.Add(45*time.Second).Format(http.TimeFormat)),
+			wantRetry: true, min: 45 * time.Second, max: 45*time.Second + 1},
+		{name: "429 with Ratelimit-Reset", method: "GET", status: 429,
+			header:    header("Ratelimit-Remaining", "0", "Ratelimit-Reset", strconv.FormatInt(now.Add(2*time.Minute).Unix(), 10)),
+			wantRetry: true, min: 2 * time.Minute, max: 2*time.Minute + retryBaseDelay},
+		{name: "429 with Retry-After and Ratelimit-Reset", method: "GET", status: 429,
+			header:    header("Retry-After", "5", "Ratelimit-Remaining", "0", "Ratelimit-Reset", strconv.FormatInt(now.Add(time.Hour).Unix(), 10)),
+			wantRetry: true, min: 5 * time.Second, max: 5*time.Second + 1},
+		{name: "429 with quota remaining", method: "GET", status: 429,
+			header:  header("Ratelimit-Remaining", "10", "Ratelimit-Reset", strconv.FormatInt(now.Add(time.Hour).Unix(), 10)),
+			attempt: 2, wantRetry: true, min: 500 * time.Millisecond, max: time.Second},
+		{name: "429 without headers", method: "GET", status: 429,
+			wantRetry: true, min: 250 * time.Millisecond, max: 500 * time.Millisecond},
+		{name: "POST 429", method: "POST", status: 429, header: header("Retry-After", "5"),
+			wantRetry: true, min: 5 * time.Second, max: 5*time.Second + 1},
+		{name: "GET 500", method: "GET", status: 500,
+			wantRetry: true, min: 250 * time.Millisecond, max: 500 * time.Millisecond},
+		{name: "PUT 503 with Retry-After", method: "PUT", status: 503, header: header("Retry-After", "2"),
+			wantRetry: true, min: 2 * time.Second, max: 2*time.Second + 1},
+		{name: "POST 500", method: "POST", status: 500},
+		{name: "POST 503 with Retry-After", method: "POST", status: 503, header: header("Retry-After", "2")},
+		{name: "GET transport error", method: "GET", err: transportErr,
+			wantRetry: true, min: 250 * time.Millisecond, max: 500 * time.Millisecond},
+		{name: "POST transport error", method: "POST", err: transportErr},
+		{name: "GET 404", method: "GET", status: 404},
+		{name: "PUT 500 with an unrewindable body", method: "PUT", unrewindable: true, status: 500},
+	}
+	for _, tt := range tests {
+		r, err := http.NewRequest(tt.method, APIBase+"/domains/example.org/records", strings.NewReader("{}"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if tt.unrewindable {
+			r.GetBody = nil
+		}
+		var resp *http.Response
+		if tt.status != 0 {
+			resp = &http.Response{StatusCode: tt.status, Header: tt.header}
+			if resp.Header == nil {
+				resp.Header = make(http.Header)
+			}
+		}
+		attempt := tt.attempt
+		if attempt == 0 {
+			attempt = 1
+		}
+
+		delay, retry := retryDelay(r, resp, tt.err, attempt, now)
+		if retry != tt.wantRetry {
+			t.Errorf("%s: got retry %t, want %t", tt.name, retry, tt.wantRetry)
+			continue
+		}
+		if retry && (delay < tt.min || delay >= tt.max) {
+			t.Errorf("%s: got delay %s, want [%s, %s)", tt.name, delay, tt.min, tt.max)
+		}
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	tests := []struct {
+		attempt  int
+		min, max time.Duration
+	}{
+		{attempt: 1, min: 250 * time.Millisecond, max: 500 * time.Millisecond},
+		{attempt: 2, min: 500 * time.Millisecond, max: time.Second},
+		{attempt: 5, min: 4 * time.Second, max: 8 * time.Second},
+		{attempt: 6, min: 5 * time.Second, max: 10 * time.Second},
+		{attempt: 100, min: 5 * time.Second, max: 10 * time.Second},
+	}
+	for _, tt := range tests {
+		for i := 0; i < 20; i++ {
+			if delay := backoff(tt.attempt); delay < tt.min || delay >= tt.max {
+				t.Errorf("backoff(%d) = %s, want [%s, %s)", tt.attempt, delay, tt.min, tt.max)
+				break
+			}
+		}
+	}
+}
+
+func TestDoContextRetries(t *testing.T) {
+	tests := []struct {
+		name      string
+		method    string
+		responses []int // the status of each response in turn; zero for a transport error
+		header    http.Header
+		budget    time.Duration
+		wantCalls int
+		wantErr   bool
+	}{
+		{name: "GET 500 retried", method: "GET", responses: []int{500, 200},
+			header: http.Header{"Retry-After": {"0"}}, budget: time.Minute, wantCalls: 2},
+		{name: "GET 429 retried", method: "GET", responses: []int{429, 429, 200},
+			header: http.Header{"Retry-After": {"0"}}, budget: time.Minute, wantCalls: 3},
+		{name: "POST 500 not retried", method: "POST", responses: []int{500, 200},
+			header: http.Header{"Retry-After": {"0"}}, budget: time.Minute, wantCalls: 1, wantErr: true},
+		{name: "POST transport error not retried", method: "POST", responses: []int{0, 200},
+			budget: time.Minute, wantCalls: 1, wantErr: true},
+		{name: "POST 429 retried", method: "POST", responses: []int{429, 200},
+			header: http.Header{"Retry-After": {"0"}}, budget: time.Minute, wantCalls: 2},
+		{name: "retry budget exhausted", method: "GET", responses: []int{429, 200},
+			header: http.Header{"Retry-After": {"60"}}, budget: time.Second, wantCalls: 1, wantErr: true},
+		{name: "retries disabled", method: "GET", responses: []int{500, 200},
+			header: http.Header{"Retry-After": {"0"}}, wantCalls: 1, wantErr: true},
+	}
+	for _, tt := range tests {
+		calls := 0
+		api := &fakeAPI{handler: func(r *http.Request) (int, http.Header, string) {
+			status := tt.responses[calls]
+			calls++
+			if status >= 400 {
+				return status, tt.header, `{"id": "error", "message": "failed"}`
+			}
+			return status, nil, `{"domain_record": {}}`
+		}}
+		c := api.client()
+		c.RetryBudget = tt.budget
+
+		r, err := http.NewRequest(tt.method, APIBase+"/domains/example.org/records", strings.NewReader("{}"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp, err := c.DoContext(context.Background(), r)
+		if resp != nil {
+			closeResponse(resp)
+		}
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: got error %v, want error: %t", tt.name, err, tt.wantErr)
+		}
+		if calls != tt.wantCalls {
+			t.Errorf("%s: got %d requests, want %d", tt.name, calls, tt.wantCalls)
+		}
+	}
+}
@@ -0,0 +1,92 @@
+// Package envsecret reads secrets, like API keys, which are named by environment variables. A secret may be
+// given directly in the environment variable, in a file named by the corresponding "_FILE" variable, or as a
+// systemd credential (see LoadCredential= in systemd.exec(5)).
+package envsecret
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// FileSuffix is appended to a secret's environment variable name to form the name of the variable
+// giving the path of a file containing the secret.
+const FileSuffix = "_FILE"
+
+// Lookup returns the secret named by the given environment variable, and whether it was found.
+// The secret is found in the first of:
+//
+//   - the environment variable itself (eg. DO_API_KEY);
+//   - the file named by the environment variable with FileSuffix appended (eg. DO_API_KEY_FILE);
+//   - the systemd credential with the same name as the environment variable, in $CREDENTIALS_DIRECTORY.
+//
+// Secrets read from files have trailing newlines removed. It's an error for both the variable and its
+// "_FILE" variant to be set, or for a secret file to be empty or readable by every user on the system.
+func Lookup(key string) (string, bool, error) {
+	value := os.Getenv(key)
+	path := os.Getenv(key + FileSuffix)
+	if value != "" && path != "" {
+		return "", false, fmt.Errorf("only one of %s and %s%s may be set", key, key, FileSuffix)
+	}
+	if value != "" {
+		return value, true, nil
+	}
+	if path != "" {
+		value, err := readSecretFile(path)
+		if err != nil {
+			return "", false, fmt.Errorf("couldn't read %s%s: %w", key, FileSuffix, err)
+		}
+		return value, true, nil
+	}
+
+	if credsDir := os.Getenv("CREDENTIALS_DIRECTORY"); credsDir != "" {
+		path := filepath.Join(credsDir, key)
+		if _, err := os.Stat(path); os.IsNotExist(err) {
+			return "", false, nil
+		}
+		value, err := readSecretFile(path)
+		if err != nil {
+			return "", false, fmt.Errorf("couldn't read credential %s: %w", key, err)
+		}
+		return value, true, nil
+	}
+	return "", false, nil
+}
+
+// CheckPermissions returns an error if the file at the given path is readable by every user on the system,
+// and so mustn't hold secrets.
+func CheckPermissions(path string) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	if info.Mode().Perm()&0004 != 0 {
+		return fmt.Errorf("'%s' is readable by all users (mode %#o); restrict its permissions, eg. with chmod o-r", path, info.Mode().Perm())
+	}
+	return nil
+}
+
+// readSecretFile reads a secret from the file at the given path, refusing files which anyone can read.
+func readSecretFile(path string) (string, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return "", err
+	}
+	if info.IsDir() {
+		return "", fmt.Errorf("'%s' is a directory", path)
+	}
+	if err := CheckPermissions(path); err != nil {
+		return "", err
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	value := strings.TrimRight(string(data), "\r\n")
+	if value == "" {
+		return "", fmt.Errorf("'%s' is empty", path)
+	}
+	return value, nil
+}
@@ -0,0 +1,60 @@
+package handler
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"do-ddns/server/app"
+)
+
+// basicAuthCredential authenticates the request's basic authorization header, which must be of the format
+// 'domain:secret' (where the domain's case and any trailing dot don't matter), against the given domain's
+// credentials. It returns the matching credential, or an error suitable for returning from a handler.
+func basicAuthCredential(r *http.Request, domainConfig app.DomainConfig) (app.Credential, error) {
+	authHdr := r.Header.Get("Authorization")
+	if !strings.HasPrefix(authHdr, "Basic ") {
+		return app.Credential{}, app.HandlerError{
+			StatusCode: http.StatusUnauthorized,
+			Err:        errors.New("authorization header doesn't look like basic auth"),
+		}
+	}
+	auth, err := base64.StdEncoding.DecodeString(authHdr[6:])
+	if err != nil {
+		return app.Credential{}, app.HandlerError{
+			StatusCode: http.StatusUnauthorized,
+			Err:        fmt.Errorf("authorization decoding error: %w", err),
+		}
+	}
+	authParts := strings.SplitN(string(auth), ":", 2)
+	if len(authParts) == 2 && domainConfig.IsDomain(authParts[0]) {
+		credential, ok, err := domainConfig.Authenticate(authParts[1], time.Now())
+		if err != nil {
+			return app.Credential{}, err
+		}
+		if ok {
+			return credential, nil
+		}
+	}
+	return app.Credential{}, app.HandlerError{
+		StatusCode:  http.StatusUnauthorized,
+		PublicError: fmt.Sprintf("incorrect authorization header for domain '%s' (must be of format 'domain:secret')", domainConfig.Domain),
+	}
+}
+
+// forbiddenUpdateError returns an error indicating that the given credential's scopes don't allow it to
+// update the domain's records of the given type. If recordType is empty, the credential may not update
+// any records.
+func forbiddenUpdateError(domainConfig app.DomainConfig, credential app.Credential, recordType string) error {
+	msg := fmt.Sprintf("credential '%s' may not update domain '%s'", credential.ID, domainConfig.Domain)
+	if recordType != "" {
+		msg = fmt.Sprintf("credential '%s' may not update %s records for domain '%s'", credential.ID, recordType, domainConfig.Domain)
+	}
+	return app.HandlerError{
+		StatusCode:  http.StatusForbidden,
+		PublicError: msg,
+	}
+}
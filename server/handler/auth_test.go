@@ -0,0 +1,47 @@
+package handler
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"do-ddns/server/app"
+	"do-ddns/server/secret"
+)
+
+func TestBasicAuthCredential(t *testing.T) {
+	const key = "derivation key"
+	tests := []struct {
+		name       string
+		domain     string
+		username   string
+		password   string
+		wantCredID string
+	}{
+		{name: "exact", domain: "home.example.org", username: "home.example.org", password: "hunter2", wantCredID: app.DefaultCredentialID},
+		{name: "mixed-case entry", domain: "Home.Example.org", username: "home.example.org", password: "hunter2", wantCredID: app.DefaultCredentialID},
+		{name: "trailing-dot entry", domain: "home.example.org.", username: "home.example.org", password: "hunter2", wantCredID: app.DefaultCredentialID},
+		{name: "mixed-case username with trailing dot", domain: "home.example.org", username: "HOME.example.org.", password: "hunter2", wantCredID: app.DefaultCredentialID},
+		{name: "derived secret, mixed-case entry with trailing dot", domain: "Home.Example.org.", username: "home.example.org", password: secret.DeriveHMACSHA256(key, "home.example.org"), wantCredID: "derived"},
+		{name: "wrong secret", domain: "home.example.org", username: "home.example.org", password: "hunter3"},
+		{name: "wrong domain", domain: "home.example.org", username: "other.example.org", password: "hunter2"},
+	}
+	for _, tt := range tests {
+		c := app.DomainConfig{
+			Domain:      tt.domain,
+			Secret:      "hunter2",
+			Credentials: []app.Credential{{ID: "derived", Secret: key, Derive: app.DeriveHMACSHA256}},
+		}
+		r := httptest.NewRequest("GET", "/", nil)
+		r.SetBasicAuth(tt.username, tt.password)
+		cred, err := basicAuthCredential(r, c)
+		if tt.wantCredID == "" {
+			if err == nil {
+				t.Errorf("%s: authenticated as '%s', want an error", tt.name, cred.ID)
+			}
+			continue
+		}
+		if err != nil || cred.ID != tt.wantCredID {
+			t.Errorf("%s: got credential '%s', %v, want '%s'", tt.name, cred.ID, err, tt.wantCredID)
+		}
+	}
+}
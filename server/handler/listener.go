@@ -0,0 +1,52 @@
+package handler
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	"do-ddns/server/app"
+)
+
+// Listener describes the listener which received a request.
+type Listener struct {
+	// Family is the IP version of the clients whose requests are accepted, or 0 if either is accepted. This lets
+	// separate listeners (eg. 0.0.0.0:7001 and [::]:7002) force IPv4 and IPv6 updates, even for a dual-stack hostname.
+	Family IPVersion
+
+	// Unix is set for Unix domain sockets. Their peers have no IP address, and are trusted as proxies, since the
+	// socket's permissions restrict who can connect.
+	Unix bool
+}
+
+type listenerKey struct{}
+
+// String returns "IPv4" or "IPv6".
+func (v IPVersion) String() string {
+	return fmt.Sprintf("IPv%d", int(v))
+}
+
+// WithListener returns a context for requests received by the given listener.
+func WithListener(ctx context.Context, l Listener) context.Context {
+	return context.WithValue(ctx, listenerKey{}, l)
+}
+
+// listenerOf returns the listener which received the request.
+func listenerOf(r *http.Request) Listener {
+	l, _ := r.Context().Value(listenerKey{}).(Listener)
+	return l
+}
+
+// checkListenerFamily returns an error if the client's IP version doesn't match the IP version accepted by
+// the listener which received the request. This happens when a dual-stack reverse proxy forwards a request
+// to the wrong listener.
+func checkListenerFamily(r *http.Request, clientIPStr string, clientIPVersion IPVersion) error {
+	family := listenerOf(r).Family
+	if family == 0 || family == clientIPVersion {
+		return nil
+	}
+	return app.HandlerError{
+		StatusCode:  http.StatusBadRequest,
+		PublicError: fmt.Sprintf("this endpoint only accepts %s clients, but the request came from %s address '%s'", family, clientIPVersion, clientIPStr),
+	}
+}
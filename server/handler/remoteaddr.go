@@ -0,0 +1,201 @@
+package handler
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+
+	"do-ddns/server/app"
+)
+
+// remoteAddr returns the client IP address, taking into account the forwarding header trusted from proxies.
+// It parses the IP, and also returns the version of the client IP.
+// If the client IP can't be parsed, or its version isn't accepted by the listener which received the request,
+// it returns only an error.
+//
+// Forwarding headers are only honored when the request's TCP peer is a trusted proxy, or when the request was
+// received via a Unix socket (in which case they're required).
+// In that case, the forwarding chain is walked from right to left, and the client IP is the
+// first hop which is not itself a trusted proxy. Only the header chosen by Env.ClientIPHeader is read; the other
+// is ignored, since a proxy which sets one header may pass a client's own copy of the other through unchanged.
+func remoteAddr(e *app.Env, r *http.Request) (string, IPVersion, error) {
+	var trustedPeer bool
+	clientIPStr, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err == nil {
+		trustedPeer = e.IsTrustedProxy(net.ParseIP(clientIPStr))
+	} else if listenerOf(r).Unix {
+		// the peer is a proxy on this host, which must say who the client is
+		clientIPStr = ""
+		trustedPeer = true
+	} else {
+		return "", 0, fmt.Errorf("invalid RemoteAddr '%s': %w", r.RemoteAddr, err)
+	}
+
+	if trustedPeer {
+		hdrName := e.ClientIPHeader()
+		var chain []string
+		if hdrName == app.ForwardedHeader {
+			chain, err = forwardedChain(r)
+		} else {
+			chain, err = forwardedForChain(r), nil
+		}
+		if err == nil && len(chain) != 0 {
+			clientIPStr, err = firstUntrustedHop(e, chain)
+		} else if err == nil && clientIPStr == "" {
+			return "", 0, app.HandlerError{
+				StatusCode:  http.StatusBadRequest,
+				PublicError: fmt.Sprintf("requests via a Unix socket must include a %s header", hdrName),
+			}
+		}
+		if err != nil {
+			return "", 0, app.HandlerError{
+				StatusCode:  http.StatusBadRequest,
+				Err:         err,
+				PublicError: fmt.Sprintf("invalid %s header", hdrName),
+			}
+		}
+	}
+
+	ipVersion, err := ipVersion(clientIPStr)
+	if err != nil {
+		return "", 0, fmt.Errorf("invalid client IP '%s': %w", clientIPStr, err)
+	}
+	if err := checkListenerFamily(r, clientIPStr, ipVersion); err != nil {
+		return "", 0, err
+	}
+
+	return clientIPStr, ipVersion, nil
+}
+
+// forwardedChain returns the list of for= nodes from all the request's forwarded headers (RFC 7239),
+// ordered from the original client to the proxy closest to this server.
+// Forwarded elements without a for= parameter are skipped.
+func forwardedChain(r *http.Request) ([]string, error) {
+	var chain []string
+	for _, hdr := range r.Header[http.CanonicalHeaderKey("forwarded")] {
+		elements, err := splitQuoted(hdr, ',')
+		if err != nil {
+			return nil, err
+		}
+		for _, element := range elements {
+			pairs, err := splitQuoted(element, ';')
+			if err != nil {
+				return nil, err
+			}
+			for _, pair := range pairs {
+				eq := strings.IndexByte(pair, '=')
+				if eq < 0 {
+					if strings.TrimSpace(pair) == "" {
+						continue
+					}
+					return nil, fmt.Errorf("malformed forwarded pair '%s'", pair)
+				}
+				if !strings.EqualFold(strings.TrimSpace(pair[:eq]), "for") {
+					continue
+				}
+				chain = append(chain, unquote(strings.TrimSpace(pair[eq+1:])))
+			}
+		}
+	}
+	return chain, nil
+}
+
+// splitQuoted splits the given header value on the given separator, ignoring separators
+// which occur inside quoted strings.
+func splitQuoted(s string, sep byte) ([]string, error) {
+	var parts []string
+	inQuotes := false
+	start := 0
+	for i := 0; i < len(s); i++ {
+		switch {
+		case s[i] == '\\' && inQuotes:
+			i++
+		case s[i] == '"':
+			inQuotes = !inQuotes
+		case s[i] == sep && !inQuotes:
+			parts = append(parts, s[start:i])
+			start = i + 1
+		}
+	}
+	if inQuotes {
+		return nil, fmt.Errorf("unterminated quoted string in '%s'", s)
+	}
+	return append(parts, s[start:]), nil
+}
+
+// unquote removes the quotes from the given quoted string, resolving any quoted-pairs (RFC 7230 §3.2.6).
+// Strings which aren't quoted are returned unchanged.
+func unquote(s string) string {
+	if len(s) < 2 || s[0] != '"' || s[len(s)-1] != '"' {
+		return s
+	}
+	var b strings.Builder
+	for i := 1; i < len(s)-1; i++ {
+		if s[i] == '\\' && i+1 < len(s)-1 {
+			i++
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
+
+// forwardedForChain returns the list of hops from all the request's x-forwarded-for headers,
+// ordered from the original client to the proxy closest to this server.
+func forwardedForChain(r *http.Request) []string {
+	var chain []string
+	for _, hdr := range r.Header[http.CanonicalHeaderKey("x-forwarded-for")] {
+		for _, hop := range strings.Split(hdr, ",") {
+			if hop = strings.TrimSpace(hop); hop != "" {
+				chain = append(chain, hop)
+			}
+		}
+	}
+	return chain
+}
+
+// firstUntrustedHop walks the given forwarding chain from right to left, returning the first hop
+// which is not a trusted proxy. If every hop is trusted, it returns the leftmost hop.
+func firstUntrustedHop(e *app.Env, chain []string) (string, error) {
+	for i := len(chain) - 1; i >= 0; i-- {
+		ip := parseHop(chain[i])
+		if ip == nil {
+			return "", fmt.Errorf("cannot parse forwarded hop '%s'", chain[i])
+		}
+		if i == 0 || !e.IsTrustedProxy(ip) {
+			return ip.String(), nil
+		}
+	}
+	return "", fmt.Errorf("empty forwarding chain")
+}
+
+// parseHop parses a single hop from a forwarding chain, which may be a bare IP address, a bracketed
+// IPv6 address, or an address with a port. It returns nil if the hop can't be parsed, including
+// for the "unknown" and obfuscated identifiers permitted by RFC 7239.
+func parseHop(hop string) net.IP {
+	if ip := net.ParseIP(hop); ip != nil {
+		return ip
+	}
+	if strings.HasPrefix(hop, "[") && strings.HasSuffix(hop, "]") {
+		return net.ParseIP(hop[1 : len(hop)-1])
+	}
+	if host, _, err := net.SplitHostPort(hop); err == nil {
+		return net.ParseIP(host)
+	}
+	return nil
+}
+
+// ipVersion returns the version of the given IP address string, or an error
+// if the address cannot be parsed.
+func ipVersion(ipStr string) (IPVersion, error) {
+	ip := net.ParseIP(ipStr)
+	if ip == nil {
+		return 0, fmt.Errorf("cannot parse IP '%s'", ipStr)
+	}
+
+	ipVersion := IPv6
+	if p4 := ip.To4(); len(p4) == net.IPv4len {
+		ipVersion = IPv4
+	}
+	return ipVersion, nil
+}
@@ -0,0 +1,218 @@
+package handler
+
+import (
+	"context"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"do-ddns/server/app"
+)
+
+func testEnv(t *testing.T, forwardedHeader string) *app.Env {
+	t.Helper()
+	trusted, err := app.ParseCIDRs("127.0.0.0/8, ::1/128, 10.0.0.0/8")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &app.Env{TrustedProxies: trusted, ForwardedHeader: forwardedHeader}
+}
+
+func TestSplitQuoted(t *testing.T) {
+	tests := []struct {
+		in      string
+		sep     byte
+		want    []string
+		wantErr bool
+	}{
+		{in: "a,b", sep: ',', want: []string{"a", "b"}},
+		{in: "a", sep: ',', want: []string{"a"}},
+		{in: "", sep: ',', want: []string{""}},
+		{in: `for="a,b",for=c`, sep: ',', want: []string{`for="a,b"`, "for=c"}},
+		{in: `for="a\",b";by=c`, sep: ';', want: []string{`for="a\",b"`, "by=c"}},
+		{in: `for="a,b`, sep: ',', wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := splitQuoted(tt.in, tt.sep)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("splitQuoted(%q): error = %v, want error %t", tt.in, err, tt.wantErr)
+			continue
+		}
+		if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("splitQuoted(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestUnquote(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{`192.0.2.1`, `192.0.2.1`},
+		{`"192.0.2.1"`, `192.0.2.1`},
+		{`"[2001:db8::1]:4711"`, `[2001:db8::1]:4711`},
+		{`"a\"b"`, `a"b`},
+		{`"a\\b"`, `a\b`},
+		{`""`, ``},
+		{`"`, `"`},
+		{`"unterminated`, `"unterminated`},
+	}
+	for _, tt := range tests {
+		if got := unquote(tt.in); got != tt.want {
+			t.Errorf("unquote(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestForwardedChain(t *testing.T) {
+	tests := []struct {
+		name    string
+		headers []string
+		want    []string
+		wantErr bool
+	}{
+		{name: "none", headers: nil, want: nil},
+		{name: "IPv4", headers: []string{"for=192.0.2.60;proto=http;by=203.0.113.43"}, want: []string{"192.0.2.60"}},
+		{name: "quoted IPv6", headers: []string{`For="[2001:db8:cafe::17]:4711"`}, want: []string{"[2001:db8:cafe::17]:4711"}},
+		{name: "list", headers: []string{"for=192.0.2.43, for=198.51.100.17"}, want: []string{"192.0.2.43", "198.51.100.17"}},
+		{name: "multiple headers", headers: []string{"for=192.0.2.43", "for=198.51.100.17;by=10.0.0.1"}, want: []string{"192.0.2.43", "198.51.100.17"}},
+		{name: "unknown", headers: []string{"for=unknown, for=10.0.0.1"}, want: []string{"unknown", "10.0.0.1"}},
+		{name: "no for", headers: []string{"proto=https;by=10.0.0.1"}, want: nil},
+		{name: "empty pairs", headers: []string{"for=192.0.2.43;;"}, want: []string{"192.0.2.43"}},
+		{name: "malformed pair", headers: []string{"for=192.0.2.43;garbage"}, wantErr: true},
+		{name: "unterminated quote", headers: []string{`for="[2001:db8::1]`}, wantErr: true},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest("GET", "/", nil)
+		for _, h := range tt.headers {
+			r.Header.Add("Forwarded", h)
+		}
+		got, err := forwardedChain(r)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: error = %v, want error %t", tt.name, err, tt.wantErr)
+			continue
+		}
+		if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: got %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestFirstUntrustedHop(t *testing.T) {
+	e := testEnv(t, "")
+	tests := []struct {
+		name    string
+		chain   []string
+		want    string
+		wantErr bool
+	}{
+		{name: "single hop", chain: []string{"192.0.2.1"}, want: "192.0.2.1"},
+		{name: "spoofed leftmost hop", chain: []string{"6.6.6.6", "192.0.2.1", "10.0.0.1"}, want: "192.0.2.1"},
+		{name: "all trusted", chain: []string{"10.0.0.2", "10.0.0.1"}, want: "10.0.0.2"},
+		{name: "bracketed IPv6 with port", chain: []string{"[2001:db8::1]:4711"}, want: "2001:db8::1"},
+		{name: "bracketed IPv6", chain: []string{"[2001:db8::1]"}, want: "2001:db8::1"},
+		{name: "IPv4 with port", chain: []string{"192.0.2.1:56324", "::1"}, want: "192.0.2.1"},
+		{name: "unknown client", chain: []string{"unknown", "10.0.0.1"}, wantErr: true},
+		{name: "unknown beyond client", chain: []string{"unknown", "192.0.2.1", "10.0.0.1"}, want: "192.0.2.1"},
+		{name: "obfuscated", chain: []string{"_hidden"}, wantErr: true},
+		{name: "empty", chain: nil, wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := firstUntrustedHop(e, tt.chain)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: error = %v, want error %t", tt.name, err, tt.wantErr)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("%s: got %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestRemoteAddr(t *testing.T) {
+	tests := []struct {
+		name            string
+		forwardedHeader string
+		remoteAddr      string
+		unix            bool
+		headers         map[string]string
+		want            string
+		wantVersion     IPVersion
+		wantErr         bool
+	}{
+		{
+			name:        "direct",
+			remoteAddr:  "192.0.2.1:1234",
+			headers:     map[string]string{"X-Forwarded-For": "6.6.6.6"},
+			want:        "192.0.2.1",
+			wantVersion: IPv4,
+		},
+		{
+			name:        "x-forwarded-for",
+			remoteAddr:  "127.0.0.1:1234",
+			headers:     map[string]string{"X-Forwarded-For": "2001:db8::1"},
+			want:        "2001:db8::1",
+			wantVersion: IPv6,
+		},
+		{
+			name:        "client's forwarded header is ignored by default",
+			remoteAddr:  "127.0.0.1:1234",
+			headers:     map[string]string{"X-Forwarded-For": "192.0.2.1", "Forwarded": "for=6.6.6.6"},
+			want:        "192.0.2.1",
+			wantVersion: IPv4,
+		},
+		{
+			name:            "client's x-forwarded-for header is ignored when forwarded is trusted",
+			forwardedHeader: app.ForwardedHeader,
+			remoteAddr:      "127.0.0.1:1234",
+			headers:         map[string]string{"X-Forwarded-For": "6.6.6.6", "Forwarded": `for="[2001:db8::1]:4711"`},
+			want:            "2001:db8::1",
+			wantVersion:     IPv6,
+		},
+		{
+			name:        "no forwarding header",
+			remoteAddr:  "127.0.0.1:1234",
+			want:        "127.0.0.1",
+			wantVersion: IPv4,
+		},
+		{
+			name:        "unix socket",
+			unix:        true,
+			remoteAddr:  "@",
+			headers:     map[string]string{"X-Forwarded-For": "192.0.2.1"},
+			want:        "192.0.2.1",
+			wantVersion: IPv4,
+		},
+		{
+			name:       "unix socket without the trusted header",
+			unix:       true,
+			remoteAddr: "@",
+			headers:    map[string]string{"Forwarded": "for=192.0.2.1"},
+			wantErr:    true,
+		},
+		{
+			name:       "unknown client",
+			remoteAddr: "127.0.0.1:1234",
+			headers:    map[string]string{"X-Forwarded-For": "unknown"},
+			wantErr:    true,
+		},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest("GET", "/", nil)
+		r.RemoteAddr = tt.remoteAddr
+		for k, v := range tt.headers {
+			r.Header.Set(k, v)
+		}
+		if tt.unix {
+			r = r.WithContext(WithListener(context.Background(), Listener{Unix: true}))
+		}
+		got, gotVersion, err := remoteAddr(testEnv(t, tt.forwardedHeader), r)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: error = %v, want error %t", tt.name, err, tt.wantErr)
+			continue
+		}
+		if got != tt.want || gotVersion != tt.wantVersion {
+			t.Errorf("%s: got %q (%v), want %q (%v)", tt.name, got, gotVersion, tt.want, tt.wantVersion)
+		}
+	}
+}
@@ -0,0 +1,71 @@
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+
+	"do-ddns/server/api"
+	"do-ddns/server/app"
+)
+
+// Status returns the current A and AAAA records for the domain given in the hostname query parameter.
+// Like the DynDns update API, it requires a basic authorization header of the format 'domain:secret';
+// any of the domain's credentials may be used, including those with the read-status scope.
+func Status(e *app.Env, w http.ResponseWriter, r *http.Request) error {
+	var statusRequest api.DomainStatusRequest
+	if err := e.Decoder.Decode(&statusRequest, r.URL.Query()); err != nil || statusRequest.Hostname == "" {
+		return app.HandlerError{
+			StatusCode:  http.StatusBadRequest,
+			Err:         err,
+			PublicError: "Invalid query parameters. (The hostname parameter is required.)",
+		}
+	}
+
+	domainConfig, ok := e.DomainConfig(statusRequest.Hostname)
+	if !ok {
+		return app.HandlerError{
+			StatusCode:  http.StatusNotFound,
+			PublicError: fmt.Sprintf("domain '%s' is not configured", statusRequest.Hostname),
+		}
+	}
+	credential, err := basicAuthCredential(r, domainConfig)
+	if err != nil {
+		return err
+	}
+	log.Printf("domain '%s': status request authenticated with credential '%s'", domainConfig.Domain, credential.ID)
+
+	zone, recordName, err := e.Zone(r.Context(), domainConfig)
+	if err != nil {
+		return providerError(err)
+	}
+	p, err := e.Provider(domainConfig)
+	if err != nil {
+		return err
+	}
+	records, err := p.GetRecords(r.Context(), zone)
+	if err != nil {
+		return providerError(err)
+	}
+
+	resp := api.DomainStatusResponse{
+		Domain: domainConfig.Domain,
+		A:      make([]string, 0),
+		AAAA:   make([]string, 0),
+	}
+	for _, record := range records {
+		if record.Name != recordName {
+			continue
+		}
+		switch record.Type {
+		case "A":
+			resp.A = append(resp.A, record.Data)
+		case "AAAA":
+			resp.AAAA = append(resp.AAAA, record.Data)
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	return json.NewEncoder(w).Encode(resp)
+}
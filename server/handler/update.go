@@ -1,25 +1,26 @@
 package handler
 
 import (
-	"encoding/base64"
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"log"
-	"net"
 	"net/http"
 	"strings"
+	"time"
 
 	"do-ddns/server/api"
 	"do-ddns/server/app"
-	"do-ddns/server/digitalocean"
+	"do-ddns/server/provider"
 
 	"github.com/crewjam/errset"
 )
 
 type IPVersion int
+
 const (
 	IPv4 IPVersion = 4
 	IPv6 IPVersion = 6
@@ -50,14 +51,22 @@ func PostUpdate(e *app.Env, w http.ResponseWriter, r *http.Request) error {
 			PublicError: fmt.Sprintf("domain '%s' is not configured", updateRequest.Domain),
 		}
 	}
-	if domainConfig.Secret != updateRequest.Secret {
+	credential, ok, err := domainConfig.Authenticate(updateRequest.Secret, time.Now())
+	if err != nil {
+		return err
+	}
+	if !ok {
 		return app.HandlerError{
 			StatusCode:  http.StatusUnauthorized,
 			PublicError: fmt.Sprintf("incorrect secret for domain '%s'", updateRequest.Domain),
 		}
 	}
+	if credential.HasScope(app.ScopeReadStatus) {
+		return forbiddenUpdateError(domainConfig, credential, "")
+	}
+	log.Printf("domain '%s': update authenticated with credential '%s'", domainConfig.Domain, credential.ID)
 
-	clientIPStr, ipVersion, err := remoteAddr(r)
+	clientIPStr, ipVersion, err := remoteAddr(e, r)
 	if err != nil {
 		return err
 	}
@@ -66,8 +75,11 @@ func PostUpdate(e *app.Env, w http.ResponseWriter, r *http.Request) error {
 	if ipVersion == IPv6 {
 		recordType = "AAAA"
 	}
+	if !credential.CanUpdate(recordType) {
+		return forbiddenUpdateError(domainConfig, credential, recordType)
+	}
 
-	if err = performUpdate(e, domainConfig, recordType, clientIPStr); err != nil {
+	if err = performUpdate(r.Context(), e, domainConfig, recordType, clientIPStr); err != nil {
 		return err
 	}
 
@@ -104,29 +116,16 @@ func DynDnsApiUpdate(e *app.Env, w http.ResponseWriter, r *http.Request) error {
 		}
 	}
 
-	authHdr := r.Header.Get("Authorization")
-	if !strings.HasPrefix(authHdr, "Basic ") {
-		return app.HandlerError{
-			StatusCode:  http.StatusUnauthorized,
-			Err:         errors.New("authorization header doesn't look like basic auth"),
-		}
-	}
-	auth, err := base64.StdEncoding.DecodeString(authHdr[6:])
+	credential, err := basicAuthCredential(r, domainConfig)
 	if err != nil {
-		return app.HandlerError{
-			StatusCode:  http.StatusUnauthorized,
-			Err:         fmt.Errorf("authorization decoding error: %w", err),
-		}
+		return err
 	}
-	validAuth := fmt.Sprintf("%s:%s", domainConfig.Domain, domainConfig.Secret)
-	if string(auth) != validAuth {
-		return app.HandlerError{
-			StatusCode:  http.StatusUnauthorized,
-			PublicError: fmt.Sprintf("incorrect authorization header for domain '%s' (must be of format 'domain:secret')", domain),
-		}
+	if credential.HasScope(app.ScopeReadStatus) {
+		return forbiddenUpdateError(domainConfig, credential, "")
 	}
+	log.Printf("domain '%s': update authenticated with credential '%s'", domainConfig.Domain, credential.ID)
 
-	clientIPStr, clientIPVersion, err := remoteAddr(r)
+	clientIPStr, clientIPVersion, err := remoteAddr(e, r)
 	if err != nil {
 		return err
 	}
@@ -140,7 +139,8 @@ func DynDnsApiUpdate(e *app.Env, w http.ResponseWriter, r *http.Request) error {
 		updateAAAARecordValue = clientIPStr
 	}
 
-	if (updateRequest.MyIP != clientIPStr) && domainConfig.AllowClientIPChoice {
+	allowClientIPChoice := domainConfig.AllowClientIPChoice && credential.CanChooseIP()
+	if (updateRequest.MyIP != clientIPStr) && allowClientIPChoice {
 		// the client IP address and the requested new IP are different, and we're allowed to trust the client's IP choice.
 		// see if we can discover both IPv4 and IPv6 addresses from this request; else, just use the client's IP choice.
 		myIPVersion, err := ipVersion(updateRequest.MyIP)
@@ -174,26 +174,46 @@ func DynDnsApiUpdate(e *app.Env, w http.ResponseWriter, r *http.Request) error {
 		}
 	}
 
+	// if both record types were discovered from this request, update only those the credential is allowed to;
+	// if only one was, the credential must be allowed to update it.
+	if updateARecordValue != "" && !credential.CanUpdate("A") {
+		if updateAAAARecordValue == "" {
+			return forbiddenUpdateError(domainConfig, credential, "A")
+		}
+		updateARecordValue = ""
+	}
+	if updateAAAARecordValue != "" && !credential.CanUpdate("AAAA") {
+		if updateARecordValue == "" {
+			return forbiddenUpdateError(domainConfig, credential, "AAAA")
+		}
+		updateAAAARecordValue = ""
+	}
+
 	errs := errset.ErrSet{}
 
 	if updateARecordValue != "" {
-		if err = performUpdate(e, domainConfig, "A", updateARecordValue); err != nil {
+		if err = performUpdate(r.Context(), e, domainConfig, "A", updateARecordValue); err != nil {
 			errs = append(errs, err)
 		}
 	}
 
 	if updateAAAARecordValue != "" {
-		if err = performUpdate(e, domainConfig, "AAAA", updateAAAARecordValue); err != nil {
+		if err = performUpdate(r.Context(), e, domainConfig, "AAAA", updateAAAARecordValue); err != nil {
 			errs = append(errs, err)
 		}
 	}
 
 	if len(errs) > 0 {
+		for _, err := range errs {
+			if errors.Is(err, provider.BusyErr) {
+				return err
+			}
+		}
 		return errs.ReturnValue()
 	}
 
 	respIP := ""
-	if domainConfig.AllowClientIPChoice {
+	if allowClientIPChoice {
 		respIP = updateRequest.MyIP
 	} else if updateARecordValue != "" {
 		respIP = updateARecordValue
@@ -206,78 +226,60 @@ func DynDnsApiUpdate(e *app.Env, w http.ResponseWriter, r *http.Request) error {
 	return err
 }
 
-// remoteAddr returns the client IP address, taking into account the x-forwarded-for header.
-// It parses the IP, and also returns the version of the client IP.
-// If the client IP can't be parsed, it returns only an error.
-func remoteAddr(r *http.Request) (string, IPVersion, error) {
-	clientIPStr, _, err := net.SplitHostPort(r.RemoteAddr)
-	if err != nil {
-		return "", 0, fmt.Errorf("invalid RemoteAddr '%s': %w", r.RemoteAddr, err)
-	}
-	forwardedFor := r.Header.Get("x-forwarded-for")
-	if forwardedFor != "" {
-		parts := strings.Split(forwardedFor, ",")
-		if len(parts) != 0 {
-			clientIPStr = strings.TrimSpace(parts[0])
-		}
+// performUpdate updates the domain's records of the given type to the given value, unless the cache indicates
+// they're already up to date. DNS provider API requests are cancelled when the given context is done.
+func performUpdate(ctx context.Context, e *app.Env, c app.DomainConfig, recordType string, value string) error {
+	if err := e.SeedAddressHistory(ctx, c, recordType); err != nil {
+		return providerError(err)
 	}
+	now := time.Now()
+	ttl := e.RecordTTL(c, recordType, value, now)
 
-	ipVersion, err := ipVersion(clientIPStr)
-	if err != nil {
-		return "", 0, fmt.Errorf("invalid client IP '%s': %w", clientIPStr, err)
-	}
-
-	return clientIPStr, ipVersion, nil
-}
-
-// ipVersion returns the version of the given IP address string, or an error
-// if the address cannot be parsed.
-func ipVersion(ipStr string) (IPVersion, error) {
-	ip := net.ParseIP(ipStr)
-	if ip == nil {
-		return 0, fmt.Errorf("cannot parse IP '%s'", ipStr)
-	}
-
-	ipVersion := IPv6
-	if p4 := ip.To4(); len(p4) == net.IPv4len {
-		ipVersion = IPv4
+	// the TTL is cached along with the value, so a changed (eg. adaptive) TTL is applied promptly
+	cacheValue := value
+	if ttl != 0 {
+		cacheValue = fmt.Sprintf("%s ttl=%d", value, ttl)
 	}
-	return ipVersion, nil
-}
-
-func performUpdate(e *app.Env, c app.DomainConfig, recordType string, value string) error {
-	if e.UpdateCache.Get(c.Domain, recordType) == value {
+	if e.UpdateCache.Get(c.Domain, recordType) == cacheValue {
 		log.Printf("cache indicates that %s record for %s is up to date", recordType, c.Domain)
 		return nil
 	}
 
-	parts := strings.Split(c.Domain, ".")
-	if len(parts) < 2 {
-		return app.HandlerError{
-			StatusCode:  http.StatusBadRequest,
-			Err:         nil,
-			PublicError: fmt.Sprintf("'%s' is not a valid domain name", c.Domain),
-		}
+	zone, recordName, err := e.Zone(ctx, c)
+	if err != nil {
+		return providerError(err)
 	}
-	rootDomain := strings.Join(parts[len(parts)-2:], ".")
-	recordName := "."
-	if len(parts) > 2 {
-		recordName = strings.Join(parts[:len(parts)-2], ".")
-	} else {
-		recordName = "@"
+
+	p, err := e.Provider(c)
+	if err != nil {
+		return err
 	}
 
-	err := e.DOAPI.UpdateRecords(rootDomain, recordName, recordType, value)
-	if err == digitalocean.NoMatchingRecordsFoundErr && c.CreateMissingRecords {
-		err = e.DOAPI.CreateRecord(rootDomain, recordName, recordType, value)
+	err = p.UpdateRecords(ctx, zone, recordName, recordType, value, ttl)
+	if err == provider.NoMatchingRecordsFoundErr && c.CreateMissingRecords {
+		err = p.CreateRecord(ctx, zone, recordName, recordType, value, ttl)
 	}
 	if err != nil {
-		return app.HandlerError{
-			StatusCode: http.StatusInternalServerError,
-			Err:        err,
-		}
+		return providerError(err)
 	}
 
-	e.UpdateCache.Set(c.Domain, recordType, value)
+	e.RecordAddress(c, recordType, value, now)
+	e.UpdateCache.Set(c.Domain, recordType, cacheValue)
 	return nil
-}
\ No newline at end of file
+}
+
+// providerError returns a HandlerError for an error from the domain's DNS provider. If the provider is too busy to
+// send the request, the client is told to try again later.
+func providerError(err error) error {
+	if errors.Is(err, provider.BusyErr) {
+		return app.HandlerError{
+			StatusCode:  http.StatusServiceUnavailable,
+			Err:         err,
+			PublicError: "server busy; try again later",
+		}
+	}
+	return app.HandlerError{
+		StatusCode: http.StatusInternalServerError,
+		Err:        err,
+	}
+}
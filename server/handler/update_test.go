@@ -0,0 +1,55 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"do-ddns/server/app"
+	"do-ddns/server/cache"
+	"do-ddns/server/provider"
+)
+
+// failingProvider is a provider.Provider whose updates fail with its error.
+type failingProvider struct {
+	provider.Provider
+	err error
+}
+
+func (p failingProvider) UpdateRecords(ctx context.Context, zone, name, recordType, value string, ttl int) error {
+	return p.err
+}
+
+func TestPerformUpdateProviderErrors(t *testing.T) {
+	tests := []struct {
+		name       string
+		err        error
+		wantStatus int
+	}{
+		{name: "busy", err: fmt.Errorf("%w (the next request could be sent in 1m0s)", provider.BusyErr), wantStatus: http.StatusServiceUnavailable},
+		{name: "busy, wrapped again", err: fmt.Errorf("failed to GET records: %w", fmt.Errorf("%w", provider.BusyErr)), wantStatus: http.StatusServiceUnavailable},
+		{name: "other error", err: errors.New("HTTP 500 Internal Server Error"), wantStatus: http.StatusInternalServerError},
+	}
+	for _, tt := range tests {
+		e := &app.Env{
+			Providers:   map[string]provider.Provider{app.DefaultProvider: failingProvider{err: tt.err}},
+			UpdateCache: &cache.DNSUpdateCache{},
+		}
+		c := app.DomainConfig{Domain: "home.example.org", Zone: "example.org"}
+
+		err := performUpdate(context.Background(), e, c, "A", "192.0.2.1")
+		var handlerErr app.HandlerError
+		if !errors.As(err, &handlerErr) {
+			t.Errorf("%s: got error %v, want a HandlerError", tt.name, err)
+			continue
+		}
+		if handlerErr.StatusCode != tt.wantStatus {
+			t.Errorf("%s: got status %d, want %d", tt.name, handlerErr.StatusCode, tt.wantStatus)
+		}
+		if e.UpdateCache.Get(c.Domain, "A") != "" {
+			t.Errorf("%s: failed update was cached", tt.name)
+		}
+	}
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"strings"
+	"syscall"
+
+	"do-ddns/server/config"
+	"do-ddns/server/handler"
+	"do-ddns/server/socketactivation"
+)
+
+// listenFamilyPrefixes maps the prefixes which may force a listen address's family (eg. "ipv6=127.0.0.1:7002")
+// to that family. This is useful behind a reverse proxy, which connects via loopback regardless of the client's family.
+var listenFamilyPrefixes = map[string]handler.IPVersion{
+	"ipv4=": handler.IPv4,
+	"ipv6=": handler.IPv6,
+}
+
+// unixPrefix begins a listen address giving the path of a Unix domain socket (eg. "unix:/run/do-ddns/http.sock").
+const unixPrefix = "unix:"
+
+// listenAddr describes an address to listen on, given in the server configuration.
+type listenAddr struct {
+	network string            // "tcp", "tcp4", "tcp6", or "unix"
+	addr    string            // the address to bind, or the socket's path
+	family  handler.IPVersion // the IP version of the clients whose requests are accepted, or 0 for either
+}
+
+// parseListenAddr parses an address to listen on. A listener whose host is an IPv4 literal (eg. "0.0.0.0:7001")
+// only accepts requests from IPv4 clients, and one whose host is an IPv6 literal (eg. "[::]:7002") only accepts
+// requests from IPv6 clients. Listeners on a hostname or on all addresses (eg. ":7001") accept either, as do
+// listeners on a loopback address or a Unix socket, since they're usually behind a reverse proxy; the family of
+// those listeners may be given by a prefix from listenFamilyPrefixes.
+func parseListenAddr(s string) (listenAddr, error) {
+	l := listenAddr{network: "tcp", addr: s}
+	for prefix, family := range listenFamilyPrefixes {
+		if strings.HasPrefix(s, prefix) {
+			l.addr = strings.TrimPrefix(s, prefix)
+			l.family = family
+		}
+	}
+
+	if strings.HasPrefix(l.addr, unixPrefix) {
+		l.network = "unix"
+		l.addr = strings.TrimPrefix(l.addr, unixPrefix)
+		if l.addr == "" {
+			return l, fmt.Errorf("invalid listen address '%s': the socket's path is missing", s)
+		}
+		return l, nil
+	}
+
+	host, _, err := net.SplitHostPort(l.addr)
+	if err != nil {
+		return l, fmt.Errorf("invalid listen address '%s': %w", s, err)
+	}
+	if ip := net.ParseIP(host); ip != nil {
+		l.network = "tcp6"
+		if ip.To4() != nil {
+			l.network = "tcp4"
+		}
+		l.inferFamily(ip)
+	}
+	return l, nil
+}
+
+// activatedListenAddr describes a listening socket passed by systemd. Its family is inferred from its address,
+// like parseListenAddr, or given by its name (set by FileDescriptorName=), which may be "ipv4" or "ipv6".
+func activatedListenAddr(sl socketactivation.Listener) listenAddr {
+	l := listenAddr{network: sl.Addr().Network(), addr: sl.Addr().String()}
+	if family, ok := listenFamilyPrefixes[sl.Name+"="]; ok {
+		l.family = family
+	} else if tcpAddr, ok := sl.Addr().(*net.TCPAddr); ok && !(tcpAddr.IP.IsUnspecified() && tcpAddr.IP.To4() == nil) {
+		// a socket on [::] may also accept IPv4 clients, depending on BindIPv6Only=
+		l.inferFamily(tcpAddr.IP)
+	}
+	return l
+}
+
+// inferFamily sets the listener's family from the IP it's bound to, unless the family is already set or
+// the IP is loopback.
+func (l *listenAddr) inferFamily(ip net.IP) {
+	if l.family != 0 || ip.IsLoopback() {
+		return
+	}
+	l.family = handler.IPv6
+	if ip.To4() != nil {
+		l.family = handler.IPv4
+	}
+}
+
+// listen opens the listener. A Unix socket is created with the given permissions, replacing any stale socket
+// left at its path by a previous process.
+func (l listenAddr) listen(unixSocketMode os.FileMode) (net.Listener, error) {
+	if l.network != "unix" {
+		return net.Listen(l.network, l.addr)
+	}
+
+	if fi, err := os.Lstat(l.addr); err == nil && fi.Mode()&os.ModeSocket != 0 {
+		if conn, err := net.Dial("unix", l.addr); err == nil {
+			conn.Close()
+			return nil, fmt.Errorf("socket '%s' is in use by another process", l.addr)
+		}
+		if err := os.Remove(l.addr); err != nil {
+			return nil, fmt.Errorf("couldn't remove stale socket '%s': %w", l.addr, err)
+		}
+	}
+
+	// set the umask, rather than chmod'ing the socket afterwards, so it's never accessible with looser permissions
+	oldUmask := syscall.Umask(int(0777 &^ unixSocketMode.Perm()))
+	listener, err := net.Listen("unix", l.addr)
+	syscall.Umask(oldUmask)
+	return listener, err
+}
+
+// String describes the listener, eg. "0.0.0.0:7001 (IPv4 clients only)".
+func (l listenAddr) String() string {
+	desc := l.addr
+	if l.network == "unix" {
+		desc = unixPrefix + l.addr
+	}
+	if l.family != 0 {
+		desc += fmt.Sprintf(" (%s clients only)", l.family)
+	}
+	return desc
+}
+
+// baseContext returns a http.Server BaseContext function deriving each request's context from the given parent,
+// and tagging it with a description of the listener.
+func (l listenAddr) baseContext(parent context.Context) func(net.Listener) context.Context {
+	return func(net.Listener) context.Context {
+		return handler.WithListener(parent, handler.Listener{
+			Family: l.family,
+			Unix:   l.network == "unix",
+		})
+	}
+}
+
+// serverListener is an open listener and its description.
+type serverListener struct {
+	net.Listener
+	desc listenAddr
+}
+
+// openListeners opens the listeners given in the configuration. If the server was started via systemd socket
+// activation, it uses the sockets passed by systemd instead.
+func openListeners(cfg config.Config) ([]serverListener, error) {
+	activated, err := socketactivation.Listeners()
+	if err != nil {
+		return nil, err
+	}
+	if len(activated) > 0 {
+		listeners := make([]serverListener, len(activated))
+		for i, sl := range activated {
+			listeners[i] = serverListener{Listener: sl.Listener, desc: activatedListenAddr(sl)}
+		}
+		log.Printf("using %d socket(s) passed by systemd, rather than the configured listen addresses\n", len(activated))
+		return listeners, nil
+	}
+
+	var listeners []serverListener
+	for _, addr := range cfg.Listen {
+		l, err := parseListenAddr(addr)
+		if err == nil {
+			var listener net.Listener
+			if listener, err = l.listen(os.FileMode(cfg.UnixSocketMode)); err == nil {
+				listeners = append(listeners, serverListener{Listener: listener, desc: l})
+				continue
+			}
+			err = fmt.Errorf("failed to listen on %s: %w", l, err)
+		}
+		for _, opened := range listeners {
+			opened.Close()
+		}
+		return nil, err
+	}
+	return listeners, nil
+}
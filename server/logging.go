@@ -0,0 +1,45 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"do-ddns/server/config"
+)
+
+// setLogFormat configures the standard logger to write in the given format (config.LogFormatText or config.LogFormatJSON).
+func setLogFormat(format string) {
+	if format == config.LogFormatJSON {
+		log.SetFlags(0)
+		log.SetOutput(jsonLogWriter{w: os.Stderr})
+	}
+}
+
+// jsonLogWriter writes each log message as a single-line JSON object, for consumption by log aggregators.
+type jsonLogWriter struct {
+	w io.Writer
+}
+
+type jsonLogEntry struct {
+	Time    string `json:"time"`
+	Message string `json:"msg"`
+}
+
+// Write conforms jsonLogWriter to io.Writer. The standard logger calls Write once per message.
+func (j jsonLogWriter) Write(p []byte) (int, error) {
+	line, err := json.Marshal(jsonLogEntry{
+		Time:    time.Now().UTC().Format(time.RFC3339Nano),
+		Message: strings.TrimSuffix(string(p), "\n"),
+	})
+	if err != nil {
+		return 0, err
+	}
+	if _, err := j.w.Write(append(line, '\n')); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
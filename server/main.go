@@ -1,17 +1,29 @@
 package main
 
 import (
+	"context"
+	"expvar"
 	"flag"
 	"fmt"
 	"log"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
+	"strings"
+	"sync"
 	"syscall"
+	"time"
 
 	"do-ddns/server/app"
 	"do-ddns/server/cache"
+	"do-ddns/server/config"
+	"do-ddns/server/configcrypt"
+	"do-ddns/server/envsecret"
 	"do-ddns/server/handler"
+	"do-ddns/server/provider"
+	"do-ddns/server/proxyproto"
+	"do-ddns/server/watch"
 
 	"github.com/gorilla/mux"
 	"github.com/gorilla/schema"
@@ -22,8 +34,27 @@ import (
 
 var BuildVersion = "dev"
 
+// domainsConfigPollInterval is how often the domains config file is checked for changes,
+// if it can't be watched via inotify.
+const domainsConfigPollInterval = 10 * time.Second
+
+// serverConfigPath and serverConfigFlags hold the server configuration given on the command line,
+// for use by readServerConfig.
+var (
+	serverConfigPath  string
+	serverConfigFlags *config.Flags
+)
+
 func main() {
 	var printVersion = flag.Bool("version", false, "Print version number, then exit.")
+	flag.StringVar(&serverConfigPath, "config", "", "Read server configuration from this `file` (JSON, YAML, or TOML; overrides SERVER_CONFIG_PATH).")
+	serverConfigFlags = config.RegisterFlags(flag.CommandLine)
+	flag.Usage = func() {
+		fmt.Fprintln(flag.CommandLine.Output(), "Usage: do-ddns-server [options] [subcommand [args]]")
+		fmt.Fprintf(flag.CommandLine.Output(), "Subcommands: %s\n", strings.Join(subcommandNames(), ", "))
+		fmt.Fprintln(flag.CommandLine.Output(), "Options:")
+		flag.PrintDefaults()
+	}
 	flag.Parse()
 
 	if *printVersion {
@@ -31,54 +62,197 @@ func main() {
 		os.Exit(0)
 	}
 
+	if flag.NArg() > 0 {
+		os.Exit(runSubcommand(flag.Arg(0), flag.Args()[1:]))
+	}
+
+	cfg, err := readServerConfig()
+	if err == nil {
+		if err = cfg.Validate(); err != nil {
+			err = fmt.Errorf("invalid server configuration: %w", err)
+		}
+	}
+	if err != nil {
+		log.Fatalln(err.Error())
+	}
+	setLogFormat(cfg.LogFormat)
+
 	appEnv := app.Env{}
-	appEnv.UpdateCache = &cache.DNSUpdateCache{}
+	appEnv.UpdateCache = &cache.DNSUpdateCache{Lifetime: time.Duration(cfg.CacheLifetime)}
 	appEnv.Decoder = schema.NewDecoder()
 
-	port := os.Getenv("PORT")
-	if port == "" {
-		log.Println("environment variable 'PORT' is missing; defaulting to 7001")
-		port = "7001"
+	if appEnv.TrustedProxies, err = app.ParseCIDRs(strings.Join(cfg.TrustedProxies, ",")); err != nil {
+		log.Fatalf("invalid trusted proxies '%s': %s\n", strings.Join(cfg.TrustedProxies, ", "), err.Error())
 	}
+	appEnv.ForwardedHeader = cfg.ForwardedHeader
 
-	doAPIKey := mustGetenv("DO_API_KEY")
-	appEnv.DOAPI = &digitalocean.APIClient{}
-	if err := appEnv.DOAPI.SetAPIKey(doAPIKey); err != nil {
+	newAPIClient := func(account string) *digitalocean.APIClient {
+		return &digitalocean.APIClient{
+			Name:            account,
+			Timeout:         time.Duration(cfg.APITimeout),
+			RetryBudget:     time.Duration(cfg.APIRetryBudget),
+			RequestsPerHour: cfg.APIRateLimit,
+			Burst:           cfg.APIBurst,
+			QueueTimeout:    time.Duration(cfg.APIQueueTimeout),
+		}
+	}
+	doAPI := newAPIClient("")
+	if err := doAPI.SetAPIKey(cfg.DOAPIKey); err != nil {
 		log.Fatalf("failed to initialize DigitalOcean API client: %s\n", err.Error())
 	}
+	appEnv.Providers = map[string]provider.Provider{
+		app.DefaultProvider: doAPI,
+	}
+	for _, account := range cfg.DOAccounts {
+		apiKey, ok, err := envsecret.Lookup(config.AccountAPIKeyEnv(account))
+		if err != nil {
+			log.Fatalf("failed to read API key for DigitalOcean account '%s': %s\n", account, err.Error())
+		}
+		if !ok {
+			log.Fatalf("API key for DigitalOcean account '%s' is missing (set %s)\n", account, config.AccountAPIKeyEnv(account))
+		}
+		accountAPI := newAPIClient(account)
+		if err := accountAPI.SetAPIKey(apiKey); err != nil {
+			log.Fatalf("failed to initialize DigitalOcean API client for account '%s': %s\n", account, err.Error())
+		}
+		appEnv.Providers[app.ProviderKey(app.DefaultProvider, account)] = accountAPI
+	}
+
+	if cfg.DomainsConfigKey != "" {
+		if appEnv.DomainsConfigKey, err = configcrypt.ParseKey(cfg.DomainsConfigKey); err != nil {
+			log.Fatalf("invalid domains config key: %s\n", err.Error())
+		}
+	}
 
-	domainsConfigPath := mustGetenv("DOMAINS_CONFIG_PATH")
+	domainsConfigPath := cfg.DomainsConfigPath
 	if err := appEnv.ReadDomainsConfig(domainsConfigPath); err != nil {
 		log.Fatalf("couldn't load config file '%s': %s\n", domainsConfigPath, err.Error())
 	}
 
+	var reloadLock sync.Mutex
+	reloadDomainsConfig := func() {
+		reloadLock.Lock()
+		defer reloadLock.Unlock()
+		if err := appEnv.ReadDomainsConfig(domainsConfigPath); err != nil {
+			log.Println(err.Error())
+			log.Println("continuing with unchanged config")
+		}
+	}
+
 	sigUSR2Chan := make(chan os.Signal, 1)
 	signal.Notify(sigUSR2Chan, syscall.SIGUSR2)
 	go func() {
 		for _ = range sigUSR2Chan {
 			log.Println("got SIGUSR2; reloading config file")
-			if err := appEnv.ReadDomainsConfig(domainsConfigPath); err != nil {
-				log.Println(err.Error())
-				log.Println("continuing with unchanged config")
-			}
+			reloadDomainsConfig()
 		}
 	}()
 
+	if cfg.DomainsConfigWatch {
+		_, err := watch.New(domainsConfigPath, domainsConfigPollInterval, func() {
+			log.Println("config file changed; reloading")
+			reloadDomainsConfig()
+		})
+		if err != nil {
+			log.Fatalf("couldn't watch config file '%s': %s\n", domainsConfigPath, err.Error())
+		}
+	}
+
 	router := mux.NewRouter().StrictSlash(false)
 	router.Methods("GET").Path("/ping").Handler(app.Handler{E: &appEnv, H: handler.Ping})
 	router.Methods("GET").Path("/v3/update").Handler(app.Handler{E: &appEnv, H: handler.DynDnsApiUpdate})
 	router.Methods("GET").Path("/nic/update").Handler(app.Handler{E: &appEnv, H: handler.DynDnsApiUpdate})
+	router.Methods("GET").Path("/status").Handler(app.Handler{E: &appEnv, H: handler.Status})
 	router.Methods("POST").Path("/").Handler(app.Handler{E: &appEnv, H: handler.PostUpdate})
-	log.Printf("server is listening on port %s\n", port)
-	log.Fatal(http.ListenAndServe(":"+port, router))
+	if cfg.DebugVars {
+		router.Methods("GET").Path("/debug/vars").Handler(expvar.Handler())
+	}
+
+	tlsConfig, acmeChallengeHandler, err := buildTLSConfig(cfg.TLS)
+	if err != nil {
+		log.Fatalln(err.Error())
+	}
+
+	listeners, err := openListeners(cfg)
+	if err != nil {
+		log.Fatalln(err.Error())
+	}
+	// requestsContext is the parent of every request's context, and is cancelled if in-flight requests don't
+	// finish within the shutdown timeout, cancelling their DNS provider API requests
+	requestsContext, cancelRequests := context.WithCancel(context.Background())
+	defer cancelRequests()
+
+	shutdownSignals := make(chan os.Signal, 1)
+	signal.Notify(shutdownSignals, syscall.SIGTERM, syscall.SIGINT)
+
+	var servers []*http.Server
+	serveErrs := make(chan error, len(listeners)+1)
+	for _, l := range listeners {
+		var listener net.Listener = l
+		if cfg.ProxyProtocol {
+			trusted := appEnv.IsTrustedProxy
+			if l.desc.network == "unix" {
+				trusted = nil // the socket's permissions restrict who can connect
+			}
+			listener = &proxyproto.Listener{Listener: listener, Trusted: trusted}
+		}
+		// each listener has its own server, so its requests can be tagged with a description of the listener
+		server := &http.Server{Handler: router, TLSConfig: tlsConfig, BaseContext: l.desc.baseContext(requestsContext)}
+		servers = append(servers, server)
+		go func() {
+			if tlsConfig != nil {
+				serveErrs <- server.ServeTLS(listener, "", "")
+			} else {
+				serveErrs <- server.Serve(listener)
+			}
+		}()
+		log.Printf("server is listening on %s\n", l.desc)
+	}
+	if cfg.ProxyProtocol {
+		log.Println("requiring PROXY protocol headers from trusted proxies")
+	}
+	if cfg.TLS.ACME.Enabled() {
+		log.Printf("serving HTTPS with ACME certificates for %s\n", strings.Join(cfg.TLS.ACME.Hosts, ", "))
+	} else if tlsConfig != nil {
+		log.Printf("serving HTTPS with certificate '%s'\n", cfg.TLS.CertFile)
+	}
+	if acmeChallengeHandler != nil {
+		challengeServer := &http.Server{Addr: cfg.TLS.ACME.HTTPListen, Handler: acmeChallengeHandler}
+		servers = append(servers, challengeServer)
+		go func() {
+			serveErrs <- challengeServer.ListenAndServe()
+		}()
+		log.Printf("answering ACME HTTP-01 challenges on %s\n", cfg.TLS.ACME.HTTPListen)
+	}
+
+	select {
+	case err := <-serveErrs:
+		log.Fatal(err)
+	case sig := <-shutdownSignals:
+		log.Printf("got %s; shutting down\n", sig)
+	}
+	shutdown(servers, time.Duration(cfg.ShutdownTimeout), cancelRequests)
 }
 
-// mustGetenv returns the value of the environment variable with the given name, or exits
-// with an error if the variable is empty.
-func mustGetenv(key string) string {
-	retv := os.Getenv(key)
-	if retv == "" {
-		log.Fatalf("environment variable '%s' is missing\n", key)
+// readServerConfig assembles the server configuration from defaults, the configuration file (given by the -config
+// flag or SERVER_CONFIG_PATH), environment variables, and command-line flags, in increasing order of precedence.
+// The configuration isn't validated.
+func readServerConfig() (config.Config, error) {
+	cfg := config.Default()
+	configPath := serverConfigPath
+	if configPath == "" {
+		configPath = os.Getenv("SERVER_CONFIG_PATH")
+	}
+	if configPath != "" {
+		if err := cfg.LoadFile(configPath); err != nil {
+			return cfg, err
+		}
+	}
+	if err := cfg.ApplyEnv(); err != nil {
+		return cfg, err
+	}
+	if err := serverConfigFlags.Apply(&cfg); err != nil {
+		return cfg, err
 	}
-	return retv
+	return cfg, nil
 }
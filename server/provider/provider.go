@@ -0,0 +1,55 @@
+// Package provider defines the interface do-ddns-server uses to manage DNS records,
+// allowing a single server to update zones hosted at different DNS providers.
+package provider
+
+import (
+	"context"
+	"errors"
+)
+
+// NoRecordsFoundErr indicates that the provider failed to find any records for the given zone.
+var NoRecordsFoundErr = errors.New("no records found for this domain")
+
+// NoMatchingRecordsFoundErr indicates that the provider failed to find any records for the given zone matching the given record name and type.
+var NoMatchingRecordsFoundErr = errors.New("no records found for this domain with the given name and type")
+
+// InvalidRecordTypeErr indicates that an invalid record type was specified.
+var InvalidRecordTypeErr = errors.New("invalid record type")
+
+// BusyErr indicates that the provider declined to send a request, because too many requests are queued
+// behind its API rate limit.
+var BusyErr = errors.New("too many DNS provider API requests are queued")
+
+// Record is a provider-independent representation of a DNS record.
+type Record struct {
+	ID   string
+	Type string
+	Name string // the record name relative to its zone; "@" for the zone apex
+	Data string
+	TTL  int
+}
+
+// Provider manages the DNS records in zones hosted at some DNS provider.
+//
+// Record names are always relative to the zone, with "@" representing the zone apex. Every method gives up
+// when the given context is done.
+type Provider interface {
+	// ListZones returns the names of all the zones hosted at this provider.
+	ListZones(ctx context.Context) ([]string, error)
+
+	// GetRecords returns all the records in the given zone.
+	GetRecords(ctx context.Context, zone string) ([]Record, error)
+
+	// UpdateRecords updates any of the zone's records with the given record name & type to the given value
+	// and TTL (in seconds), leaving the TTL unchanged if ttl is 0.
+	// It returns NoMatchingRecordsFoundErr if the zone has no such records.
+	UpdateRecords(ctx context.Context, zone string, recordName string, recordType string, value string, ttl int) error
+
+	// CreateRecord creates a record in the zone with the given name, type, value, and TTL (in seconds),
+	// using the provider's default TTL if ttl is 0.
+	CreateRecord(ctx context.Context, zone string, recordName string, recordType string, value string, ttl int) error
+
+	// DeleteRecords deletes any of the zone's records with the given record name & type.
+	// It returns NoMatchingRecordsFoundErr if the zone has no such records.
+	DeleteRecords(ctx context.Context, zone string, recordName string, recordType string) error
+}
@@ -0,0 +1,239 @@
+// Package proxyproto implements a net.Listener which accepts connections from load balancers speaking
+// HAProxy's PROXY protocol (versions 1 and 2), as described at
+// https://www.haproxy.org/download/2.0/doc/proxy-protocol.txt
+package proxyproto
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const defaultHeaderTimeout = 10 * time.Second
+
+// v1Prefix begins every PROXY protocol v1 header.
+var v1Prefix = []byte("PROXY ")
+
+// v2Signature begins every PROXY protocol v2 header.
+var v2Signature = []byte("\r\n\r\n\x00\r\nQUIT\n")
+
+// MissingHeaderErr indicates that a connection did not begin with a PROXY protocol header.
+var MissingHeaderErr = errors.New("connection did not begin with a PROXY protocol header")
+
+// UntrustedPeerErr indicates that a connection came from a peer which is not allowed to send PROXY protocol headers.
+var UntrustedPeerErr = errors.New("peer is not allowed to send PROXY protocol headers")
+
+// Listener wraps a net.Listener, requiring that each accepted connection begin with a PROXY protocol
+// header. Connections report the client address from that header as their RemoteAddr.
+type Listener struct {
+	net.Listener
+
+	// Trusted reports whether the given peer is allowed to send PROXY protocol headers.
+	// Connections from other peers are closed without being read. If nil, every peer is trusted.
+	Trusted func(ip net.IP) bool
+
+	// HeaderTimeout limits how long to wait for a connection's PROXY protocol header.
+	// If zero, a 10 second timeout is used.
+	HeaderTimeout time.Duration
+}
+
+// Accept waits for and returns the next connection to the listener.
+// The connection's PROXY protocol header is read lazily, on the first call to Read or RemoteAddr,
+// so that a slow peer can't block other connections from being accepted.
+func (l *Listener) Accept() (net.Conn, error) {
+	c, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	headerTimeout := l.HeaderTimeout
+	if headerTimeout == 0 {
+		headerTimeout = defaultHeaderTimeout
+	}
+	return &Conn{
+		Conn:          c,
+		trusted:       l.Trusted,
+		headerTimeout: headerTimeout,
+	}, nil
+}
+
+// Conn is a connection accepted by a Listener.
+type Conn struct {
+	net.Conn
+	trusted       func(ip net.IP) bool
+	headerTimeout time.Duration
+
+	once       sync.Once
+	reader     *bufio.Reader
+	remoteAddr net.Addr
+	headerErr  error
+}
+
+// Read reads data from the connection, after its PROXY protocol header.
+func (c *Conn) Read(b []byte) (int, error) {
+	c.once.Do(c.readHeader)
+	if c.headerErr != nil {
+		return 0, c.headerErr
+	}
+	return c.reader.Read(b)
+}
+
+// RemoteAddr returns the client address given in the connection's PROXY protocol header.
+// If the header doesn't specify a client address (eg. for health checks from the load balancer itself),
+// or if the header can't be read, it returns the address of the connection's peer.
+func (c *Conn) RemoteAddr() net.Addr {
+	c.once.Do(c.readHeader)
+	if c.remoteAddr != nil {
+		return c.remoteAddr
+	}
+	return c.Conn.RemoteAddr()
+}
+
+func (c *Conn) readHeader() {
+	if c.trusted != nil {
+		var peerIP net.IP
+		if tcpAddr, ok := c.Conn.RemoteAddr().(*net.TCPAddr); ok {
+			peerIP = tcpAddr.IP
+		}
+		if !c.trusted(peerIP) {
+			c.headerErr = fmt.Errorf("%w: %s", UntrustedPeerErr, c.Conn.RemoteAddr())
+			return
+		}
+	}
+
+	if err := c.Conn.SetReadDeadline(time.Now().Add(c.headerTimeout)); err != nil {
+		c.headerErr = err
+		return
+	}
+	c.reader = bufio.NewReader(c.Conn)
+	c.remoteAddr, c.headerErr = readHeader(c.reader)
+	if c.headerErr != nil {
+		c.headerErr = fmt.Errorf("invalid PROXY protocol header from %s: %w", c.Conn.RemoteAddr(), c.headerErr)
+		return
+	}
+	c.headerErr = c.Conn.SetReadDeadline(time.Time{})
+}
+
+// readHeader reads a PROXY protocol v1 or v2 header from the given reader, returning the client
+// address it specifies. The returned address is nil if the header doesn't specify a client address.
+func readHeader(r *bufio.Reader) (net.Addr, error) {
+	sig, err := r.Peek(len(v1Prefix))
+	if err != nil {
+		return nil, err
+	}
+	if bytes.Equal(sig, v1Prefix) {
+		return readV1Header(r)
+	}
+	sig, err = r.Peek(len(v2Signature))
+	if err != nil {
+		return nil, err
+	}
+	if bytes.Equal(sig, v2Signature) {
+		return readV2Header(r)
+	}
+	return nil, MissingHeaderErr
+}
+
+// readV1Header reads a human-readable PROXY protocol v1 header, such as
+// "PROXY TCP4 192.0.2.1 198.51.100.1 56324 443\r\n".
+func readV1Header(r *bufio.Reader) (net.Addr, error) {
+	const maxV1HeaderLen = 107
+	var line []byte
+	for {
+		b, err := r.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		line = append(line, b)
+		if b == '\n' {
+			break
+		}
+		if len(line) >= maxV1HeaderLen {
+			return nil, errors.New("v1 header is too long")
+		}
+	}
+	if !bytes.HasSuffix(line, []byte("\r\n")) {
+		return nil, errors.New("v1 header is not terminated by CRLF")
+	}
+
+	fields := strings.Split(string(line[:len(line)-2]), " ")
+	if len(fields) >= 2 && fields[1] == "UNKNOWN" {
+		return nil, nil
+	}
+	if len(fields) != 6 {
+		return nil, fmt.Errorf("malformed v1 header '%s'", strings.TrimSpace(string(line)))
+	}
+	if fields[1] != "TCP4" && fields[1] != "TCP6" {
+		return nil, fmt.Errorf("unsupported v1 protocol '%s'", fields[1])
+	}
+	ip := net.ParseIP(fields[2])
+	if ip == nil || (fields[1] == "TCP4") != (ip.To4() != nil) {
+		return nil, fmt.Errorf("invalid v1 source address '%s'", fields[2])
+	}
+	port, err := strconv.ParseUint(fields[4], 10, 16)
+	if err != nil {
+		return nil, fmt.Errorf("invalid v1 source port '%s'", fields[4])
+	}
+	return &net.TCPAddr{IP: ip, Port: int(port)}, nil
+}
+
+// readV2Header reads a binary PROXY protocol v2 header.
+func readV2Header(r *bufio.Reader) (net.Addr, error) {
+	const (
+		cmdLocal = 0x0
+		cmdProxy = 0x1
+		famTCP4  = 0x11
+		famTCP6  = 0x21
+	)
+
+	hdr := make([]byte, len(v2Signature)+4)
+	if _, err := io.ReadFull(r, hdr); err != nil {
+		return nil, err
+	}
+	verCmd, fam := hdr[12], hdr[13]
+	addrLen := binary.BigEndian.Uint16(hdr[14:16])
+	if verCmd>>4 != 2 {
+		return nil, fmt.Errorf("unsupported version %d", verCmd>>4)
+	}
+	addrs := make([]byte, addrLen)
+	if _, err := io.ReadFull(r, addrs); err != nil {
+		return nil, err
+	}
+
+	switch verCmd & 0xF {
+	case cmdLocal:
+		return nil, nil
+	case cmdProxy:
+	default:
+		return nil, fmt.Errorf("unsupported v2 command %d", verCmd&0xF)
+	}
+
+	switch fam {
+	case famTCP4:
+		if addrLen < 12 {
+			return nil, errors.New("v2 address block is too short for TCP4")
+		}
+		return &net.TCPAddr{
+			IP:   net.IP(addrs[0:4]),
+			Port: int(binary.BigEndian.Uint16(addrs[8:10])),
+		}, nil
+	case famTCP6:
+		if addrLen < 36 {
+			return nil, errors.New("v2 address block is too short for TCP6")
+		}
+		return &net.TCPAddr{
+			IP:   net.IP(addrs[0:16]),
+			Port: int(binary.BigEndian.Uint16(addrs[32:34])),
+		}, nil
+	default:
+		// unspecified, UDP, or Unix socket addresses, which don't describe a TCP client
+		return nil, nil
+	}
+}
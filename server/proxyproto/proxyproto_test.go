@@ -0,0 +1,175 @@
+package proxyproto
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io/ioutil"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func v2Header(verCmd, fam byte, addrs []byte) []byte {
+	var b bytes.Buffer
+	b.Write(v2Signature)
+	b.WriteByte(verCmd)
+	b.WriteByte(fam)
+	_ = binary.Write(&b, binary.BigEndian, uint16(len(addrs)))
+	b.Write(addrs)
+	return b.Bytes()
+}
+
+func TestReadV1Header(t *testing.T) {
+	tests := []struct {
+		name    string
+		in      string
+		want    string
+		wantErr bool
+	}{
+		{name: "TCP4", in: "PROXY TCP4 192.0.2.1 198.51.100.1 56324 443\r\n", want: "192.0.2.1:56324"},
+		{name: "TCP6", in: "PROXY TCP6 2001:db8::1 2001:db8::2 56324 443\r\n", want: "[2001:db8::1]:56324"},
+		{name: "UNKNOWN", in: "PROXY UNKNOWN\r\n", want: ""},
+		{name: "UNKNOWN with addresses", in: "PROXY UNKNOWN ffff:f...f:ffff ffff:f...f:ffff 65535 65535\r\n", want: ""},
+		{name: "LF only", in: "PROXY TCP4 192.0.2.1 198.51.100.1 56324 443\n", wantErr: true},
+		{name: "too few fields", in: "PROXY TCP4 192.0.2.1 198.51.100.1 56324\r\n", wantErr: true},
+		{name: "unsupported protocol", in: "PROXY UDP4 192.0.2.1 198.51.100.1 56324 443\r\n", wantErr: true},
+		{name: "family mismatch", in: "PROXY TCP4 2001:db8::1 2001:db8::2 56324 443\r\n", wantErr: true},
+		{name: "bad port", in: "PROXY TCP4 192.0.2.1 198.51.100.1 65536 443\r\n", wantErr: true},
+		{name: "too long", in: "PROXY " + strings.Repeat("X", 200) + "\r\n", wantErr: true},
+		{name: "truncated", in: "PROXY TCP4 192.0.2.1", wantErr: true},
+	}
+	for _, tt := range tests {
+		addr, err := readV1Header(bufio.NewReader(strings.NewReader(tt.in)))
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: error = %v, want error %t", tt.name, err, tt.wantErr)
+			continue
+		}
+		got := ""
+		if addr != nil {
+			got = addr.String()
+		}
+		if got != tt.want {
+			t.Errorf("%s: got %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestReadV2Header(t *testing.T) {
+	tcp4 := []byte{192, 0, 2, 1, 198, 51, 100, 1, 0xDC, 0x04, 0x01, 0xBB}
+	tcp6 := make([]byte, 36)
+	copy(tcp6, net.ParseIP("2001:db8::1"))
+	copy(tcp6[16:], net.ParseIP("2001:db8::2"))
+	binary.BigEndian.PutUint16(tcp6[32:], 56324)
+	binary.BigEndian.PutUint16(tcp6[34:], 443)
+
+	tests := []struct {
+		name    string
+		in      []byte
+		want    string
+		wantErr bool
+	}{
+		{name: "TCP4", in: v2Header(0x21, 0x11, tcp4), want: "192.0.2.1:56324"},
+		{name: "TCP4 with TLVs", in: v2Header(0x21, 0x11, append(append([]byte{}, tcp4...), 0x04, 0x00, 0x01, 0x00)), want: "192.0.2.1:56324"},
+		{name: "TCP6", in: v2Header(0x21, 0x21, tcp6), want: "[2001:db8::1]:56324"},
+		{name: "LOCAL", in: v2Header(0x20, 0x00, nil), want: ""},
+		{name: "LOCAL with addresses", in: v2Header(0x20, 0x11, tcp4), want: ""},
+		{name: "UNSPEC", in: v2Header(0x21, 0x00, nil), want: ""},
+		{name: "UDP4", in: v2Header(0x21, 0x12, tcp4), want: ""},
+		{name: "short TCP4 block", in: v2Header(0x21, 0x11, tcp4[:8]), wantErr: true},
+		{name: "short TCP6 block", in: v2Header(0x21, 0x21, tcp6[:32]), wantErr: true},
+		{name: "truncated address block", in: v2Header(0x21, 0x11, tcp4)[:20], wantErr: true},
+		{name: "truncated header", in: v2Signature, wantErr: true},
+		{name: "bad version", in: v2Header(0x11, 0x11, tcp4), wantErr: true},
+		{name: "bad command", in: v2Header(0x22, 0x11, tcp4), wantErr: true},
+	}
+	for _, tt := range tests {
+		addr, err := readV2Header(bufio.NewReader(bytes.NewReader(tt.in)))
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: error = %v, want error %t", tt.name, err, tt.wantErr)
+			continue
+		}
+		got := ""
+		if addr != nil {
+			got = addr.String()
+		}
+		if got != tt.want {
+			t.Errorf("%s: got %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestReadHeaderMissing(t *testing.T) {
+	_, err := readHeader(bufio.NewReader(strings.NewReader("GET / HTTP/1.1\r\nHost: example.org\r\n\r\n")))
+	if !errors.Is(err, MissingHeaderErr) {
+		t.Errorf("got %v, want %v", err, MissingHeaderErr)
+	}
+}
+
+// dial accepts a connection on a new Listener, writes the given data to it from the client side, and returns
+// the accepted connection, along with a function which closes everything.
+func dial(t *testing.T, trusted func(ip net.IP) bool, data string) (net.Conn, func()) {
+	t.Helper()
+	inner, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	l := &Listener{Listener: inner, Trusted: trusted, HeaderTimeout: time.Second}
+
+	client, err := net.Dial("tcp", inner.Addr().String())
+	if err != nil {
+		inner.Close()
+		t.Fatal(err)
+	}
+	closeAll := func() {
+		client.Close()
+		inner.Close()
+	}
+	if _, err := client.Write([]byte(data)); err != nil {
+		closeAll()
+		t.Fatal(err)
+	}
+
+	c, err := l.Accept()
+	if err != nil {
+		closeAll()
+		t.Fatal(err)
+	}
+	return c, func() {
+		c.Close()
+		closeAll()
+	}
+}
+
+func TestConn(t *testing.T) {
+	c, closeAll := dial(t, nil, "PROXY TCP4 192.0.2.1 198.51.100.1 56324 443\r\nhello")
+	defer closeAll()
+	if got := c.RemoteAddr().String(); got != "192.0.2.1:56324" {
+		t.Errorf("RemoteAddr() = %s, want 192.0.2.1:56324", got)
+	}
+	buf := make([]byte, 5)
+	if _, err := c.Read(buf); err != nil || string(buf) != "hello" {
+		t.Errorf("Read() = %q, %v, want \"hello\"", buf, err)
+	}
+}
+
+func TestConnLocal(t *testing.T) {
+	c, closeAll := dial(t, nil, string(v2Header(0x20, 0x00, nil)))
+	defer closeAll()
+	if got := c.RemoteAddr().(*net.TCPAddr).IP.String(); got != "127.0.0.1" {
+		t.Errorf("RemoteAddr() = %s, want the peer's address", got)
+	}
+}
+
+func TestConnUntrustedPeer(t *testing.T) {
+	c, closeAll := dial(t, func(ip net.IP) bool { return false }, "PROXY TCP4 192.0.2.1 198.51.100.1 56324 443\r\n")
+	defer closeAll()
+	if got := c.RemoteAddr().(*net.TCPAddr).IP.String(); got != "127.0.0.1" {
+		t.Errorf("RemoteAddr() = %s, want the peer's address", got)
+	}
+	if _, err := ioutil.ReadAll(c); !errors.Is(err, UntrustedPeerErr) {
+		t.Errorf("Read() error = %v, want %v", err, UntrustedPeerErr)
+	}
+}
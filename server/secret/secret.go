@@ -0,0 +1,194 @@
+// Package secret verifies client secrets against the secrets stored in the domains configuration,
+// which may be stored in plaintext or as bcrypt or argon2id hashes.
+package secret
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Argon2idPrefix begins every argon2id hash, in the PHC string format produced by HashArgon2id.
+const Argon2idPrefix = "$argon2id$"
+
+// argon2id parameters used by HashArgon2id, following the OWASP recommendations.
+const (
+	argon2idMemory  = 19 * 1024 // KiB
+	argon2idTime    = 2
+	argon2idThreads = 1
+	argon2idKeyLen  = 32
+	argon2idSaltLen = 16
+)
+
+var bcryptPrefixes = []string{"$2a$", "$2b$", "$2y$"}
+
+// IsHashed returns whether the given stored secret is a bcrypt or argon2id hash, rather than plaintext.
+func IsHashed(stored string) bool {
+	return isArgon2id(stored) || isBcrypt(stored)
+}
+
+// Validate returns an error if the given stored secret looks like a bcrypt or argon2id hash, but can't be parsed.
+func Validate(stored string) error {
+	switch {
+	case isArgon2id(stored):
+		_, err := parseArgon2id(stored)
+		return err
+	case isBcrypt(stored):
+		_, err := bcrypt.Cost([]byte(stored))
+		if err != nil {
+			return fmt.Errorf("malformed bcrypt hash: %w", err)
+		}
+	}
+	return nil
+}
+
+// Verify reports whether the given secret matches the stored secret, which may be a bcrypt hash, an argon2id
+// hash (in PHC string format), or plaintext. Verification takes constant time with respect to the secret.
+//
+// It returns an error if the stored secret looks like a hash but can't be parsed.
+func Verify(stored string, secret string) (bool, error) {
+	switch {
+	case isArgon2id(stored):
+		return verifyArgon2id(stored, secret)
+	case isBcrypt(stored):
+		err := bcrypt.CompareHashAndPassword([]byte(stored), []byte(secret))
+		if err == bcrypt.ErrMismatchedHashAndPassword {
+			return false, nil
+		}
+		return err == nil, err
+	default:
+		// compare digests, so the comparison doesn't leak the stored secret's length
+		storedDigest := sha256.Sum256([]byte(stored))
+		secretDigest := sha256.Sum256([]byte(secret))
+		return subtle.ConstantTimeCompare(storedDigest[:], secretDigest[:]) == 1, nil
+	}
+}
+
+// HashArgon2id returns an argon2id hash of the given secret, in PHC string format
+// (eg. "$argon2id$v=19$m=19456,t=2,p=1$<salt>$<hash>").
+func HashArgon2id(secret string) (string, error) {
+	salt := make([]byte, argon2idSaltLen)
+	if _, err := rand.Read(salt); err != nil {
+		return "", fmt.Errorf("failed to generate salt: %w", err)
+	}
+	key := argon2.IDKey([]byte(secret), salt, argon2idTime, argon2idMemory, argon2idThreads, argon2idKeyLen)
+	return fmt.Sprintf("%sv=%d$m=%d,t=%d,p=%d$%s$%s",
+		Argon2idPrefix,
+		argon2.Version,
+		argon2idMemory,
+		argon2idTime,
+		argon2idThreads,
+		base64.RawStdEncoding.EncodeToString(salt),
+		base64.RawStdEncoding.EncodeToString(key)), nil
+}
+
+// HashBcrypt returns a bcrypt hash of the given secret.
+func HashBcrypt(secret string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(secret), bcrypt.DefaultCost)
+	if err != nil {
+		return "", err
+	}
+	return string(hash), nil
+}
+
+// DeriveHMACSHA256 derives a per-host secret from the given key: the hex-encoded HMAC-SHA256 of the
+// lowercased hostname, without any trailing dot. This allows many hosts to have distinct secrets, without
+// configuring each one.
+func DeriveHMACSHA256(key string, hostname string) string {
+	mac := hmac.New(sha256.New, []byte(key))
+	mac.Write([]byte(strings.ToLower(strings.TrimSuffix(hostname, "."))))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func isArgon2id(stored string) bool {
+	return strings.HasPrefix(stored, Argon2idPrefix)
+}
+
+func isBcrypt(stored string) bool {
+	for _, p := range bcryptPrefixes {
+		if strings.HasPrefix(stored, p) {
+			return true
+		}
+	}
+	return false
+}
+
+// argon2idHash holds the parameters and key parsed from an argon2id hash.
+type argon2idHash struct {
+	memory  uint32
+	time    uint32
+	threads uint8
+	salt    []byte
+	key     []byte
+}
+
+// parseArgon2id parses an argon2id hash in PHC string format.
+func parseArgon2id(stored string) (argon2idHash, error) {
+	h := argon2idHash{}
+	parts := strings.Split(stored, "$")
+	if len(parts) != 6 {
+		return h, fmt.Errorf("malformed argon2id hash")
+	}
+
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil {
+		return h, fmt.Errorf("malformed argon2id version: %w", err)
+	}
+	if parts[2] != fmt.Sprintf("v=%d", version) {
+		return h, fmt.Errorf("malformed argon2id version '%s'", parts[2])
+	}
+	if version != argon2.Version {
+		return h, fmt.Errorf("unsupported argon2id version %d", version)
+	}
+
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &h.memory, &h.time, &h.threads); err != nil {
+		return h, fmt.Errorf("malformed argon2id parameters: %w", err)
+	}
+	// Sscanf ignores anything after the last parameter
+	if parts[3] != fmt.Sprintf("m=%d,t=%d,p=%d", h.memory, h.time, h.threads) {
+		return h, fmt.Errorf("malformed argon2id parameters '%s'", parts[3])
+	}
+	// argon2.IDKey panics if time or threads is 0, and RFC 9106 requires at least 8 KiB of memory per thread
+	if h.time < 1 {
+		return h, fmt.Errorf("argon2id time parameter must be at least 1")
+	}
+	if h.threads < 1 {
+		return h, fmt.Errorf("argon2id parallelism parameter must be at least 1")
+	}
+	if h.memory < 8*uint32(h.threads) {
+		return h, fmt.Errorf("argon2id memory parameter must be at least 8 KiB per thread")
+	}
+
+	var err error
+	if h.salt, err = base64.RawStdEncoding.DecodeString(parts[4]); err != nil {
+		return h, fmt.Errorf("malformed argon2id salt: %w", err)
+	}
+	if h.key, err = base64.RawStdEncoding.DecodeString(parts[5]); err != nil {
+		return h, fmt.Errorf("malformed argon2id hash: %w", err)
+	}
+	if len(h.salt) == 0 {
+		return h, fmt.Errorf("argon2id salt is empty")
+	}
+	if len(h.key) == 0 {
+		return h, fmt.Errorf("argon2id hash is empty")
+	}
+	return h, nil
+}
+
+// verifyArgon2id verifies the secret against the given argon2id hash, using the parameters encoded in the hash.
+func verifyArgon2id(stored string, secret string) (bool, error) {
+	h, err := parseArgon2id(stored)
+	if err != nil {
+		return false, err
+	}
+	candidate := argon2.IDKey([]byte(secret), h.salt, h.time, h.memory, h.threads, uint32(len(h.key)))
+	return subtle.ConstantTimeCompare(h.key, candidate) == 1, nil
+}
@@ -0,0 +1,113 @@
+package secret
+
+import "testing"
+
+// testArgon2id is an argon2id hash of "hunter2", with minimal parameters so the tests run quickly.
+const testArgon2id = "$argon2id$v=19$m=8,t=1,p=1$c2FsdHNhbHQ$BvYl4l0TaJzFo0xiz3clgdzDvFLjGvj8h5uaxZhpo0Y"
+
+func TestHashRoundTrip(t *testing.T) {
+	hashers := map[string]func(string) (string, error){
+		"argon2id": HashArgon2id,
+		"bcrypt":   HashBcrypt,
+	}
+	for name, hash := range hashers {
+		stored, err := hash("correct horse")
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if !IsHashed(stored) {
+			t.Errorf("%s: IsHashed(%q) = false", name, stored)
+		}
+		if err := Validate(stored); err != nil {
+			t.Errorf("%s: Validate(%q) = %v", name, stored, err)
+		}
+		if ok, err := Verify(stored, "correct horse"); !ok || err != nil {
+			t.Errorf("%s: Verify(correct secret) = %t, %v", name, ok, err)
+		}
+		if ok, err := Verify(stored, "battery staple"); ok || err != nil {
+			t.Errorf("%s: Verify(wrong secret) = %t, %v", name, ok, err)
+		}
+	}
+}
+
+func TestVerifyPlaintext(t *testing.T) {
+	if ok, err := Verify("hunter2", "hunter2"); !ok || err != nil {
+		t.Errorf("Verify(correct secret) = %t, %v", ok, err)
+	}
+	for _, wrong := range []string{"hunter", "hunter22", "Hunter2", ""} {
+		if ok, err := Verify("hunter2", wrong); ok || err != nil {
+			t.Errorf("Verify(%q) = %t, %v", wrong, ok, err)
+		}
+	}
+}
+
+func TestVerifyArgon2id(t *testing.T) {
+	if ok, err := Verify(testArgon2id, "hunter2"); !ok || err != nil {
+		t.Errorf("Verify(correct secret) = %t, %v", ok, err)
+	}
+	if ok, err := Verify(testArgon2id, "hunter3"); ok || err != nil {
+		t.Errorf("Verify(wrong secret) = %t, %v", ok, err)
+	}
+}
+
+func TestValidateArgon2id(t *testing.T) {
+	const (
+		salt = "c2FsdHNhbHQ"
+		key  = "BvYl4l0TaJzFo0xiz3clgdzDvFLjGvj8h5uaxZhpo0Y"
+	)
+	tests := []struct {
+		name   string
+		stored string
+	}{
+		{name: "too few parts", stored: "$argon2id$v=19$m=8,t=1,p=1$" + salt},
+		{name: "unsupported version", stored: "$argon2id$v=16$m=8,t=1,p=1$" + salt + "$" + key},
+		{name: "trailing version", stored: "$argon2id$v=19x$m=8,t=1,p=1$" + salt + "$" + key},
+		{name: "missing parameter", stored: "$argon2id$v=19$m=8,t=1$" + salt + "$" + key},
+		{name: "trailing parameters", stored: "$argon2id$v=19$m=8,t=1,p=1,keyid=x$" + salt + "$" + key},
+		{name: "zero time", stored: "$argon2id$v=19$m=8,t=0,p=1$" + salt + "$" + key},
+		{name: "zero threads", stored: "$argon2id$v=19$m=8,t=1,p=0$" + salt + "$" + key},
+		{name: "zero memory", stored: "$argon2id$v=19$m=0,t=1,p=1$" + salt + "$" + key},
+		{name: "too little memory per thread", stored: "$argon2id$v=19$m=8,t=1,p=2$" + salt + "$" + key},
+		{name: "empty salt", stored: "$argon2id$v=19$m=8,t=1,p=1$$" + key},
+		{name: "empty key", stored: "$argon2id$v=19$m=8,t=1,p=1$" + salt + "$"},
+		{name: "malformed salt", stored: "$argon2id$v=19$m=8,t=1,p=1$!!!$" + key},
+		{name: "malformed key", stored: "$argon2id$v=19$m=8,t=1,p=1$" + salt + "$!!!"},
+	}
+	if err := Validate(testArgon2id); err != nil {
+		t.Errorf("Validate(%q) = %v", testArgon2id, err)
+	}
+	for _, tt := range tests {
+		if err := Validate(tt.stored); err == nil {
+			t.Errorf("%s: Validate() = nil, want error", tt.name)
+		}
+		// Verify must return an error, rather than panicking, for a hash which Validate rejects
+		if _, err := Verify(tt.stored, "hunter2"); err == nil {
+			t.Errorf("%s: Verify() error = nil, want error", tt.name)
+		}
+	}
+}
+
+func TestValidateBcrypt(t *testing.T) {
+	if err := Validate("$2a$10$notreallyahash"); err == nil {
+		t.Error("Validate(malformed bcrypt hash) = nil, want error")
+	}
+	if err := Validate("not a hash"); err != nil {
+		t.Errorf("Validate(plaintext) = %v, want nil", err)
+	}
+}
+
+func TestDeriveHMACSHA256(t *testing.T) {
+	a := DeriveHMACSHA256("key", "Host.Example.org")
+	if b := DeriveHMACSHA256("key", "host.example.org"); a != b {
+		t.Errorf("derived secrets differ by hostname case: %s, %s", a, b)
+	}
+	if b := DeriveHMACSHA256("key", "host.example.org."); a != b {
+		t.Errorf("derived secrets differ by trailing dot: %s, %s", a, b)
+	}
+	if b := DeriveHMACSHA256("other key", "host.example.org"); a == b {
+		t.Error("derived secrets don't depend on the key")
+	}
+	if len(a) != 64 {
+		t.Errorf("derived secret %q isn't a hex-encoded SHA-256 digest", a)
+	}
+}
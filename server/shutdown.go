@@ -0,0 +1,55 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// shutdownCancelGrace is how long in-flight requests are given to finish after their DNS provider API requests
+// are cancelled at shutdown.
+const shutdownCancelGrace = 5 * time.Second
+
+// shutdown stops the servers gracefully: they stop accepting connections, and in-flight requests are given up to
+// timeout to finish, so an update isn't interrupted between changing one record and the next. After the timeout,
+// cancelRequests is called to cancel the requests' contexts, and with them their DNS provider API requests, and
+// the remaining requests are given shutdownCancelGrace to fail before their connections are closed.
+func shutdown(servers []*http.Server, timeout time.Duration, cancelRequests func()) {
+	if drain(servers, timeout) {
+		log.Println("shut down cleanly")
+		return
+	}
+
+	log.Printf("in-flight requests didn't finish within %s; cancelling DNS provider API requests\n", timeout)
+	cancelRequests()
+	if drain(servers, shutdownCancelGrace) {
+		log.Println("shut down after cancelling in-flight requests")
+		return
+	}
+
+	log.Println("closing remaining connections")
+	for _, server := range servers {
+		server.Close()
+	}
+}
+
+// drain calls Shutdown on each server, returning whether all of them finished within the given timeout.
+// It may be called again, to wait longer.
+func drain(servers []*http.Server, timeout time.Duration) bool {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	var wg sync.WaitGroup
+	for _, server := range servers {
+		wg.Add(1)
+		go func(server *http.Server) {
+			defer wg.Done()
+			// listeners are already closed on a repeated call, so only the context's error matters
+			_ = server.Shutdown(ctx)
+		}(server)
+	}
+	wg.Wait()
+	return ctx.Err() == nil
+}
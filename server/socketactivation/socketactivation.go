@@ -0,0 +1,65 @@
+// Package socketactivation receives the listening sockets passed to a service by systemd socket activation,
+// as described in sd_listen_fds(3).
+package socketactivation
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// listenFDsStart is the first file descriptor passed by systemd.
+const listenFDsStart = 3
+
+// Listener is a listening socket passed by systemd.
+type Listener struct {
+	net.Listener
+
+	// Name is the socket's name, given by FileDescriptorName= in its systemd socket unit. It defaults to the
+	// socket unit's name.
+	Name string
+}
+
+// Listeners returns the listening sockets passed to this process by systemd, or none if the process wasn't
+// socket-activated. The environment variables describing the sockets are unset, so they aren't inherited by
+// child processes. Sockets which aren't stream listeners (eg. datagram sockets) are rejected.
+func Listeners() ([]Listener, error) {
+	defer os.Unsetenv("LISTEN_PID")
+	defer os.Unsetenv("LISTEN_FDS")
+	defer os.Unsetenv("LISTEN_FDNAMES")
+
+	pid, err := strconv.Atoi(os.Getenv("LISTEN_PID"))
+	if err != nil || pid != os.Getpid() {
+		return nil, nil
+	}
+	count, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if err != nil || count < 1 {
+		return nil, nil
+	}
+	names := strings.Split(os.Getenv("LISTEN_FDNAMES"), ":")
+
+	listeners := make([]Listener, 0, count)
+	for i := 0; i < count; i++ {
+		fd := listenFDsStart + i
+		syscall.CloseOnExec(fd)
+
+		name := ""
+		if i < len(names) {
+			name = names[i]
+		}
+		f := os.NewFile(uintptr(fd), name)
+		l, err := net.FileListener(f)
+		f.Close() // FileListener has its own copy of the descriptor
+		if err != nil {
+			for _, opened := range listeners {
+				opened.Close()
+			}
+			return nil, fmt.Errorf("socket %d ('%s') passed by systemd isn't a listening stream socket: %w", fd, name, err)
+		}
+		listeners = append(listeners, Listener{Listener: l, Name: name})
+	}
+	return listeners, nil
+}
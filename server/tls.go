@@ -0,0 +1,65 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+
+	"do-ddns/server/config"
+
+	"golang.org/x/crypto/acme"
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// buildTLSConfig returns the TLS configuration for the server's listeners, or nil if HTTPS isn't configured.
+// If ACME HTTP-01 challenges should be answered, it also returns the handler for the ACME HTTP listener.
+func buildTLSConfig(cfg config.TLSConfig) (*tls.Config, http.Handler, error) {
+	if !cfg.Enabled() {
+		return nil, nil, nil
+	}
+	if !cfg.ACME.Enabled() {
+		cert, err := tls.LoadX509KeyPair(cfg.CertFile, cfg.KeyFile)
+		if err != nil {
+			return nil, nil, fmt.Errorf("couldn't load TLS certificate '%s': %w", cfg.CertFile, err)
+		}
+		return &tls.Config{
+			Certificates: []tls.Certificate{cert},
+			MinVersion:   tls.VersionTLS12,
+		}, nil, nil
+	}
+
+	client := &acme.Client{DirectoryURL: cfg.ACME.DirectoryURL}
+	if client.DirectoryURL == "" {
+		client.DirectoryURL = acme.LetsEncryptURL
+	}
+	if cfg.ACME.CARootsFile != "" {
+		pem, err := ioutil.ReadFile(cfg.ACME.CARootsFile)
+		if err != nil {
+			return nil, nil, fmt.Errorf("couldn't read ACME CA roots '%s': %w", cfg.ACME.CARootsFile, err)
+		}
+		roots := x509.NewCertPool()
+		if !roots.AppendCertsFromPEM(pem) {
+			return nil, nil, fmt.Errorf("no certificates found in ACME CA roots '%s'", cfg.ACME.CARootsFile)
+		}
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		transport.TLSClientConfig = &tls.Config{RootCAs: roots}
+		client.HTTPClient = &http.Client{Transport: transport}
+	}
+	m := &autocert.Manager{
+		Prompt:     autocert.AcceptTOS,
+		Cache:      autocert.DirCache(cfg.ACME.CacheDir),
+		HostPolicy: autocert.HostWhitelist(cfg.ACME.Hosts...),
+		Email:      cfg.ACME.Email,
+		Client:     client,
+	}
+	tlsConfig := m.TLSConfig()
+	tlsConfig.MinVersion = tls.VersionTLS12
+
+	var challengeHandler http.Handler
+	if cfg.ACME.HTTPListen != "" {
+		challengeHandler = m.HTTPHandler(nil)
+	}
+	return tlsConfig, challengeHandler, nil
+}
@@ -0,0 +1,151 @@
+// Package watch notifies callers when a configuration file, or a directory of configuration files, changes
+// on disk. It uses inotify (via fsnotify) where available, falling back to polling the files' metadata.
+package watch
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// settleDelay is how long to wait after a change before notifying, so a burst of events
+// (eg. an editor's write-then-rename) results in a single notification.
+const settleDelay = 500 * time.Millisecond
+
+// Watcher watches a single file, or the files in a directory, for changes.
+type Watcher struct {
+	path         string
+	isDir        bool
+	pollInterval time.Duration
+	onChange     func()
+	done         chan struct{}
+}
+
+// New starts watching the file at the given path, calling onChange (on a background goroutine) after
+// the file is created, written, replaced, or removed. If inotify is unavailable, the file is polled
+// at the given interval.
+//
+// The file's parent directory is watched, rather than the file itself, so that replacing the file
+// via rename (as editors and configuration management tools commonly do) is noticed.
+//
+// If the path is a directory, onChange is called after any file within it is created, written, renamed,
+// or removed. Subdirectories aren't watched.
+func New(path string, pollInterval time.Duration, onChange func()) (*Watcher, error) {
+	absPath, err := filepath.Abs(path)
+	if err != nil {
+		return nil, err
+	}
+	info, err := os.Stat(absPath)
+	w := &Watcher{
+		path:         absPath,
+		isDir:        err == nil && info.IsDir(),
+		pollInterval: pollInterval,
+		onChange:     onChange,
+		done:         make(chan struct{}),
+	}
+
+	watchPath := filepath.Dir(absPath)
+	if w.isDir {
+		watchPath = absPath
+	}
+	fsw, err := fsnotify.NewWatcher()
+	if err == nil {
+		if err = fsw.Add(watchPath); err != nil {
+			fsw.Close()
+		}
+	}
+	if err != nil {
+		log.Printf("can't watch '%s' for changes (%s); polling every %s instead", path, err.Error(), pollInterval)
+		go w.poll()
+		return w, nil
+	}
+
+	go w.watch(fsw)
+	return w, nil
+}
+
+// Close stops watching.
+func (w *Watcher) Close() {
+	close(w.done)
+}
+
+func (w *Watcher) watch(fsw *fsnotify.Watcher) {
+	defer fsw.Close()
+	var settle <-chan time.Time
+	for {
+		select {
+		case <-w.done:
+			return
+		case event, ok := <-fsw.Events:
+			if !ok {
+				return
+			}
+			if name := filepath.Clean(event.Name); name == w.path || (w.isDir && filepath.Dir(name) == w.path) {
+				settle = time.After(settleDelay)
+			}
+		case err, ok := <-fsw.Errors:
+			if !ok {
+				return
+			}
+			log.Printf("error watching '%s': %s", w.path, err.Error())
+		case <-settle:
+			settle = nil
+			w.onChange()
+		}
+	}
+}
+
+func (w *Watcher) poll() {
+	ticker := time.NewTicker(w.pollInterval)
+	defer ticker.Stop()
+	last := w.fingerprint()
+	for {
+		select {
+		case <-w.done:
+			return
+		case <-ticker.C:
+			if current := w.fingerprint(); current != last {
+				last = current
+				w.onChange()
+			}
+		}
+	}
+}
+
+// fileFingerprint summarizes the metadata used to detect changes when polling.
+type fileFingerprint struct {
+	exists  bool
+	size    int64
+	modTime time.Time
+	entries string // for a directory, the names, sizes, and modification times of the files within it
+}
+
+func (w *Watcher) fingerprint() fileFingerprint {
+	info, err := os.Stat(w.path)
+	if err != nil {
+		return fileFingerprint{}
+	}
+	fp := fileFingerprint{
+		exists:  true,
+		size:    info.Size(),
+		modTime: info.ModTime(),
+	}
+	if info.IsDir() {
+		files, err := ioutil.ReadDir(w.path)
+		if err != nil {
+			return fp
+		}
+		var entries strings.Builder
+		for _, f := range files {
+			fmt.Fprintf(&entries, "%s\x00%d\x00%d\n", f.Name(), f.Size(), f.ModTime().UnixNano())
+		}
+		fp.entries = entries.String()
+	}
+	return fp
+}